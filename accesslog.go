@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// accessLogOut is where access log lines are written
+var accessLogOut io.Writer = os.Stdout
+
+// accessLogWriter captures the response status and size for the access log
+type accessLogWriter struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	clientIP string  // resolved client IP, set by mainHandler
+	tokens   float64 // rate limit tokens left after the request, negative if unknown
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (flushing, deadlines)
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessLogRecord is a single JSON access log line
+type accessLogRecord struct {
+	Time      string   `json:"time"`
+	Method    string   `json:"method"`
+	Path      string   `json:"path"`
+	IP        string   `json:"ip"`
+	Status    int      `json:"status"`
+	Bytes     int64    `json:"bytes"`
+	Tokens    *float64 `json:"tokens,omitempty"`
+	LatencyMs float64  `json:"latency_ms"`
+}
+
+// accessLogHandler wraps a handler to emit one JSON log line per request
+func accessLogHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &accessLogWriter{ResponseWriter: w, tokens: -1}
+		next.ServeHTTP(lw, r)
+
+		record := accessLogRecord{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			IP:        lw.clientIP,
+			Status:    lw.status,
+			Bytes:     lw.bytes,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if *logRedactKeys {
+			record.Path = redactPath(r.URL.Path)
+		}
+		if record.IP == "" {
+			// Request was answered before the client IP was resolved
+			record.IP, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+		if record.Status == 0 {
+			record.Status = http.StatusOK
+		}
+		if lw.tokens >= 0 {
+			tokens := math.Round(lw.tokens*100) / 100
+			record.Tokens = &tokens
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		accessLogOut.Write(append(line, '\n'))
+	})
+}
+
+// annotateAccessLog records client details for the access log, if it is enabled
+func annotateAccessLog(w http.ResponseWriter, clientIP string, tokens float64) {
+	if lw, ok := w.(*accessLogWriter); ok {
+		lw.clientIP = clientIP
+		lw.tokens = tokens
+	}
+}
+
+// redactPath hides key names, keeping only the namespace: "/ip/1.2.3.4/svc" becomes "/ip/*".
+// Reserved paths like "/_list" are kept as is.
+func redactPath(path string) string {
+	trimmed := strings.TrimPrefix(path, "/")
+	if trimmed == "" || strings.HasPrefix(trimmed, "_") && !strings.Contains(trimmed, "/") {
+		return path
+	}
+	if namespace, _, ok := strings.Cut(trimmed, "/"); ok {
+		return "/" + namespace + "/*"
+	}
+	return "/*"
+}
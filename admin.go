@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminRoutes maps reserved paths to handlers that require the admin token.
+// Cleared on startup without -adminToken, so the admin API doesn't exist at all.
+var adminRoutes = map[string]http.HandlerFunc{
+	"/_list":    listHandler,
+	"/_export":  exportHandler,
+	"/_import":  importHandler,
+	"/_stats":   statsHandler,
+	"/_sync":    syncHandler,
+	"/_prefix":  deletePrefixHandler,
+	"/_block":   blockHandler,
+	"/_version": versionHandler,
+	"/_purge":   purgeHandler,
+	// Same as /debug/vars with -expvar, but behind the admin token and without taking a key name
+	"/_debug/vars": expvarHandler,
+}
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// startTime is when the process started, reported as uptime by /_version
+var startTime = time.Now()
+
+// checkAdmin verifies the admin token header, writing an error response if it's missing or wrong.
+// The comparison takes constant time, so the token can't be guessed byte by byte.
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := r.Header.Get(*adminHeader)
+	if token == "" {
+		writeError(w, "Unauthorized: Admin token required", http.StatusUnauthorized)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) != 1 {
+		writeError(w, "Unauthorized: Incorrect admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// listHandler returns a JSON array of keys starting with the "prefix" query parameter
+func listHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	keys := []string{}
+	truncated := false
+	now := time.Now()
+	kvMap.Range(func(key string, entry *Entry) bool {
+		// Expired keys not yet removed don't exist for clients either
+		if !strings.HasPrefix(key, prefix) || entry.expired(key, now) {
+			return true
+		}
+		if len(keys) >= *maxListResults {
+			truncated = true
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	sort.Strings(keys)
+
+	if truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// storeStats is the JSON response of /_stats
+type storeStats struct {
+	Keys             int            `json:"keys"`
+	MaxKeys          int            `json:"maxKeys"`
+	OwnedKeys        int            `json:"ownedKeys"`
+	AnonymousKeys    int            `json:"anonymousKeys"`
+	ValueBytes       int64          `json:"valueBytes"`
+	Namespaces       map[string]int `json:"namespaces"` // keys per top-level prefix ("ip/"), "" for keys without one
+	OldestLastUpdate int64          `json:"oldestLastUpdate,omitempty"`
+	Connections      int64          `json:"connections"`
+	MaxConnections   int            `json:"maxConnections,omitempty"`
+	Ephemeral        bool           `json:"ephemeral,omitempty"` // the store file couldn't be opened, data is in memory only
+}
+
+// versionInfo is the JSON response of /_version
+type versionInfo struct {
+	Version    string `json:"version"`
+	Revision   string `json:"revision,omitempty"` // VCS commit, if the binary was built from a checkout
+	GoVersion  string `json:"goVersion"`
+	Uptime     int64  `json:"uptime"` // seconds
+	Goroutines int    `json:"goroutines"`
+}
+
+// versionHandler reports the deployed build and a few runtime numbers
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	info := versionInfo{
+		Version:    version,
+		GoVersion:  runtime.Version(),
+		Uptime:     int64(time.Since(startTime) / time.Second),
+		Goroutines: runtime.NumGoroutine(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Revision = setting.Value
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// statsHandler returns usage statistics of the store
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	stats := storeStats{
+		MaxKeys:        *maxNumKV,
+		Namespaces:     make(map[string]int),
+		Connections:    activeConns.Load(),
+		MaxConnections: *maxConns,
+		Ephemeral:      ephemeral,
+	}
+	kvMap.Range(func(key string, entry *Entry) bool {
+		stats.Keys++
+		if entry.Secret != "" {
+			stats.OwnedKeys++
+		} else {
+			stats.AnonymousKeys++
+		}
+		stats.ValueBytes += int64(len(entry.Value))
+		stats.Namespaces[topLevelPrefix(key)]++
+		if stats.OldestLastUpdate == 0 || entry.LastUpdate < stats.OldestLastUpdate {
+			stats.OldestLastUpdate = entry.LastUpdate
+		}
+		return true
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// syncHandler flushes all pending changes to the store file and fsyncs it
+func syncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if ephemeral {
+		writeError(w, "Store is running in ephemeral mode", http.StatusServiceUnavailable)
+		return
+	}
+	if err := kvMap.Sync(); err != nil {
+		log.Printf("Error syncing store: %v", err)
+		writeError(w, "Error syncing store", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// purgeHandler removes expired keys right away instead of waiting for the next cleanup
+func purgeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	removed, _ := purgeExpiredKeys(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+}
+
+// deletePrefixHandler deletes all keys starting with the "prefix" query parameter.
+// An empty prefix matches every key, so "confirm=true" is required to guard against accidents.
+func deletePrefixHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodDelete)
+		return
+	}
+	query := r.URL.Query()
+	if confirm, _ := strconv.ParseBool(query.Get("confirm")); !confirm {
+		writeError(w, "Add confirm=true to delete keys", http.StatusBadRequest)
+		return
+	}
+	prefix := query.Get("prefix")
+
+	var keys []string
+	kvMap.Range(func(key string, entry *Entry) bool {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	deleted := 0
+	for _, key := range keys {
+		if _, existed := deleteKey(key); existed {
+			addTombstone(key)
+			notifyKey(key)
+			deleted++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestListSkipsExpired(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "adminToken", "t")
+	serve(post("p/live", "v"))
+	serve(post("other", "v"))
+	kvMap.Set("p/old", &Entry{Value: []byte("v"), LastUpdate: time.Now().Add(-3 * time.Hour).Unix()})
+
+	r := httptest.NewRequest(http.MethodGet, "/_list?prefix=p/", nil)
+	r.Header.Set("X-Admin-Token", "t")
+	w := serve(r)
+	var keys []string
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatalf("list isn't valid JSON: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "p/live" {
+		t.Fatalf("listed %q, want only p/live", keys)
+	}
+}
+
+func TestAdminExpvar(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "adminToken", "t")
+	r := httptest.NewRequest(http.MethodGet, "/_debug/vars", nil)
+	r.Header.Set("X-Admin-Token", "t")
+	w := serve(r)
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil || vars["keys"] == nil {
+		t.Fatalf("/_debug/vars: got %d %s", w.Code, w.Body)
+	}
+
+	// Without -expvar the standard path is an ordinary key
+	serve(post("debug/vars", "v"))
+	if w := serve(httptest.NewRequest(http.MethodGet, "/debug/vars", nil)); w.Body.String() != "v" {
+		t.Fatalf("key debug/vars answered %q", w.Body)
+	}
+}
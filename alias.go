@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// validateAlias checks the X-Alias target of a POST to key
+func validateAlias(key, target string) error {
+	if target == key {
+		return errors.New("Alias can't point to itself")
+	}
+	if len(target) > *maxKeySize || validateKey(target) != nil || isReservedKey(target) {
+		return errors.New("Invalid X-Alias")
+	}
+	// Aliases are a single hop, so they can't point to other aliases
+	if entry, exists := getEntry(target); exists && entry.Alias != "" {
+		return errors.New("X-Alias target is an alias")
+	}
+	return nil
+}
+
+// resolveAlias returns the key and entry an alias entry points to. Chains are never followed,
+// an alias whose target has since become an alias itself resolves to nothing.
+func resolveAlias(alias *Entry) (target string, entry *Entry, exists bool) {
+	target = alias.Alias
+	entry, exists = getEntry(target)
+	if exists && entry.Alias != "" {
+		return target, nil, false
+	}
+	return target, entry, exists
+}
+
+// redirectAlias responds with a redirect to the target of the alias, keeping the query string
+func redirectAlias(w http.ResponseWriter, r *http.Request, alias *Entry) {
+	location := (&url.URL{Path: "/" + alias.Alias, RawQuery: r.URL.RawQuery}).String()
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusFound)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jipok/go-persist"
+)
+
+// storeBackend keeps the key-value pairs. Update runs fn atomically for the key,
+// so fn must not call other methods of the backend except Size.
+type storeBackend interface {
+	Open(path string) error
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+	Delete(key string)
+	// Update returns the entry stored under key after fn has run
+	Update(key string, fn func(upd *entryUpdate)) (*Entry, bool)
+	Range(fn func(key string, entry *Entry) bool)
+	Size() int
+	// SaveRateLimits replaces the rate limit state kept for the next start, LoadRateLimits returns it
+	SaveRateLimits(buckets map[string]savedBucket)
+	LoadRateLimits() map[string]savedBucket
+	// Sync flushes pending changes to disk
+	Sync() error
+	Close() error
+}
+
+// updateAction is what an Update callback decided to do with the key
+type updateAction int
+
+const (
+	updateSet updateAction = iota // store Value, the default
+	updateDelete
+	updateCancel
+)
+
+// entryUpdate is passed to storeBackend.Update callbacks with the current state of the key
+type entryUpdate struct {
+	Value  *Entry
+	Exists bool
+	action updateAction
+}
+
+// Set replaces the entry
+func (u *entryUpdate) Set(entry *Entry) {
+	u.Value = entry
+	u.action = updateSet
+}
+
+// Delete removes the key
+func (u *entryUpdate) Delete() {
+	u.action = updateDelete
+}
+
+// Cancel leaves the key unchanged
+func (u *entryUpdate) Cancel() {
+	u.action = updateCancel
+}
+
+// persistBackend stores entries in a go-persist file, synced every syncInterval
+type persistBackend struct {
+	store        *persist.Store
+	kv           *persist.PersistMap[*Entry]
+	rateLimits   *persist.PersistMap[savedBucket]
+	syncInterval time.Duration
+}
+
+func newPersistBackend(syncInterval time.Duration) (*persistBackend, error) {
+	store := persist.New()
+	kv, err := persist.Map[*Entry](store, "kv")
+	if err != nil {
+		return nil, err
+	}
+	rateLimits, err := persist.Map[savedBucket](store, "ratelimit")
+	if err != nil {
+		return nil, err
+	}
+	return &persistBackend{store: store, kv: kv, rateLimits: rateLimits, syncInterval: syncInterval}, nil
+}
+
+func (b *persistBackend) Open(path string) error {
+	if err := b.store.Open(path); err != nil {
+		return err
+	}
+	b.store.SetSyncInterval(b.syncInterval)
+	return nil
+}
+
+func (b *persistBackend) Get(key string) (*Entry, bool) {
+	return b.kv.Get(key)
+}
+
+func (b *persistBackend) Set(key string, entry *Entry) {
+	b.kv.SetAsync(key, entry)
+}
+
+func (b *persistBackend) Delete(key string) {
+	b.kv.DeleteAsync(key)
+}
+
+func (b *persistBackend) Update(key string, fn func(upd *entryUpdate)) (*Entry, bool) {
+	return b.kv.UpdateAsync(key, func(pu *persist.Update[*Entry]) {
+		upd := entryUpdate{Value: pu.Value, Exists: pu.Exists}
+		fn(&upd)
+		switch upd.action {
+		case updateSet:
+			pu.Set(upd.Value)
+		case updateDelete:
+			pu.Delete()
+		case updateCancel:
+			pu.Cancel()
+		}
+	})
+}
+
+func (b *persistBackend) Range(fn func(key string, entry *Entry) bool) {
+	b.kv.Range(fn)
+}
+
+func (b *persistBackend) Size() int {
+	return b.kv.Size()
+}
+
+func (b *persistBackend) SaveRateLimits(buckets map[string]savedBucket) {
+	b.rateLimits.Range(func(key string, _ savedBucket) bool {
+		if _, kept := buckets[key]; !kept {
+			b.rateLimits.DeleteAsync(key)
+		}
+		return true
+	})
+	for key, saved := range buckets {
+		b.rateLimits.SetAsync(key, saved)
+	}
+}
+
+func (b *persistBackend) LoadRateLimits() map[string]savedBucket {
+	buckets := make(map[string]savedBucket, b.rateLimits.Size())
+	b.rateLimits.Range(func(key string, saved savedBucket) bool {
+		buckets[key] = saved
+		return true
+	})
+	return buckets
+}
+
+func (b *persistBackend) Sync() error {
+	return b.store.FSyncAll()
+}
+
+func (b *persistBackend) Close() error {
+	return b.store.Close()
+}
+
+// memoryBackend keeps entries in memory only, everything is lost on restart
+type memoryBackend struct {
+	mu      sync.RWMutex
+	entries map[string]*Entry
+	size    atomic.Int64 // len(entries), readable without the lock
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{entries: make(map[string]*Entry)}
+}
+
+func (b *memoryBackend) Open(path string) error {
+	return nil
+}
+
+func (b *memoryBackend) Get(key string) (*Entry, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entry, exists := b.entries[key]
+	return entry, exists
+}
+
+func (b *memoryBackend) Set(key string, entry *Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[key] = entry
+	b.size.Store(int64(len(b.entries)))
+}
+
+func (b *memoryBackend) Delete(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.entries, key)
+	b.size.Store(int64(len(b.entries)))
+}
+
+func (b *memoryBackend) Update(key string, fn func(upd *entryUpdate)) (*Entry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, exists := b.entries[key]
+	upd := entryUpdate{Value: entry, Exists: exists}
+	fn(&upd)
+	switch upd.action {
+	case updateSet:
+		b.entries[key] = upd.Value
+		b.size.Store(int64(len(b.entries)))
+		return upd.Value, true
+	case updateDelete:
+		delete(b.entries, key)
+		b.size.Store(int64(len(b.entries)))
+		return nil, false
+	}
+	return entry, exists
+}
+
+// Range iterates over a snapshot, so fn may modify the backend
+func (b *memoryBackend) Range(fn func(key string, entry *Entry) bool) {
+	b.mu.RLock()
+	keys := make([]string, 0, len(b.entries))
+	entries := make([]*Entry, 0, len(b.entries))
+	for key, entry := range b.entries {
+		keys = append(keys, key)
+		entries = append(entries, entry)
+	}
+	b.mu.RUnlock()
+	for i, key := range keys {
+		if !fn(key, entries[i]) {
+			return
+		}
+	}
+}
+
+func (b *memoryBackend) Size() int {
+	return int(b.size.Load())
+}
+
+// Rate limit state can't outlive the process in memory
+func (b *memoryBackend) SaveRateLimits(buckets map[string]savedBucket) {}
+
+func (b *memoryBackend) LoadRateLimits() map[string]savedBucket {
+	return nil
+}
+
+func (b *memoryBackend) Sync() error {
+	return nil
+}
+
+func (b *memoryBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat is used in backup file names, it sorts chronologically
+const backupTimeFormat = "20060102-150405"
+
+// backupStore copies the store file into *backupDir under a timestamped name.
+// Records are flushed first. The file is append-only, so the copy is a consistent prefix of it;
+// a record being written at the same time may be cut, which the loader ignores as incomplete.
+func backupStore() error {
+	if err := kvMap.Sync(); err != nil {
+		return err
+	}
+	src, err := os.Open(*storePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Base(*storePath)
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext) + "-" + time.Now().UTC().Format(backupTimeFormat) + ext
+	tmpPath := filepath.Join(*backupDir, name+".tmp")
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(dst, src, info.Size()); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// Only complete copies get the final name
+	return os.Rename(tmpPath, filepath.Join(*backupDir, name))
+}
+
+// pruneBackups removes the oldest backups beyond *backupKeep
+func pruneBackups() error {
+	base := filepath.Base(*storePath)
+	ext := filepath.Ext(base)
+	backups, err := filepath.Glob(filepath.Join(*backupDir, strings.TrimSuffix(base, ext)+"-*"+ext))
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > *backupKeep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backupPeriodically copies the store file to *backupDir every *backupInterval until ctx is cancelled
+func backupPeriodically(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*backupInterval):
+		}
+		if err := backupStore(); err != nil {
+			log.Printf("Backup failed: %v", err)
+			continue
+		}
+		if err := pruneBackups(); err != nil {
+			log.Printf("Error removing old backups: %v", err)
+		}
+	}
+}
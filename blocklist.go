@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// blockedFileNets are loaded from the -blocklist file, replaced on SIGHUP
+	blockedFileNets []*net.IPNet
+	// blockedNets are added at runtime through /_block and are not persisted
+	blockedNets []*net.IPNet
+	// blocklistMu protects blockedFileNets and blockedNets
+	blocklistMu sync.RWMutex
+)
+
+// isBlocked reports whether the client address is in the blocklist
+func isBlocked(ip net.IP) bool {
+	blocklistMu.RLock()
+	defer blocklistMu.RUnlock()
+	return ipInNets(ip, blockedFileNets) || ipInNets(ip, blockedNets)
+}
+
+// loadBlocklist reads a file with one IP address or CIDR per line, "#" starts a comment
+func loadBlocklist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var nets []*net.IPNet
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		parsed, err := parseCIDRList(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		nets = append(nets, parsed...)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	blocklistMu.Lock()
+	blockedFileNets = nets
+	blocklistMu.Unlock()
+	return nil
+}
+
+// blockHandler manages the runtime blocklist: GET lists all blocked networks,
+// POST adds and DELETE removes the address or CIDR given in the "ip" query parameter
+func blockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		blocklistMu.RLock()
+		list := []string{}
+		for _, n := range append(append([]*net.IPNet{}, blockedFileNets...), blockedNets...) {
+			list = append(list, n.String())
+		}
+		blocklistMu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodDelete)
+		return
+	}
+	parsed, err := parseCIDRList(r.URL.Query().Get("ip"))
+	if err != nil || len(parsed) != 1 {
+		writeError(w, "Invalid ip", http.StatusBadRequest)
+		return
+	}
+	target := parsed[0]
+
+	blocklistMu.Lock()
+	defer blocklistMu.Unlock()
+	// Drop an existing copy either way, so adding twice doesn't duplicate it
+	kept := blockedNets[:0]
+	for _, n := range blockedNets {
+		if n.String() != target.String() {
+			kept = append(kept, n)
+		}
+	}
+	blockedNets = kept
+	if r.Method == http.MethodPost {
+		blockedNets = append(blockedNets, target)
+	}
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"hash/maphash"
+	"sync/atomic"
+)
+
+const (
+	// bloomSlotsPerKey and bloomHashes give about 1% false positives at -maxNumKV keys
+	bloomSlotsPerKey = 10
+	bloomHashes      = 5
+)
+
+// countingBloom is a counting bloom filter of keys. Unlike a plain bloom filter
+// keys can be removed, so deleted keys don't fill it up over time.
+type countingBloom struct {
+	seed     maphash.Seed
+	counters []atomic.Uint32
+}
+
+func newCountingBloom(keys int) *countingBloom {
+	return &countingBloom{
+		seed:     maphash.MakeSeed(),
+		counters: make([]atomic.Uint32, max(keys, 1024)*bloomSlotsPerKey),
+	}
+}
+
+// slots calls fn with each counter of the key, using double hashing
+func (f *countingBloom) slots(key string, fn func(c *atomic.Uint32)) {
+	h := maphash.String(f.seed, key)
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	for i := uint32(0); i < bloomHashes; i++ {
+		fn(&f.counters[(h1+i*h2)%uint32(len(f.counters))])
+	}
+}
+
+func (f *countingBloom) add(key string) {
+	f.slots(key, func(c *atomic.Uint32) { c.Add(1) })
+}
+
+func (f *countingBloom) remove(key string) {
+	f.slots(key, func(c *atomic.Uint32) { c.Add(^uint32(0)) })
+}
+
+// mayContain reports false only if the key is certainly not in the filter
+func (f *countingBloom) mayContain(key string) bool {
+	found := true
+	f.slots(key, func(c *atomic.Uint32) {
+		if c.Load() == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// bloomBackend wraps a backend for -bloomFilter, answering lookups of missing keys
+// from the filter without touching the store. False positives fall back to the real lookup.
+type bloomBackend struct {
+	storeBackend
+	filter *countingBloom
+}
+
+// newBloomBackend wraps an opened backend, adding the keys it already holds to the filter
+func newBloomBackend(backend storeBackend, keys int) *bloomBackend {
+	b := &bloomBackend{storeBackend: backend, filter: newCountingBloom(keys)}
+	backend.Range(func(key string, _ *Entry) bool {
+		b.filter.add(key)
+		return true
+	})
+	return b
+}
+
+func (b *bloomBackend) Get(key string) (*Entry, bool) {
+	if !b.filter.mayContain(key) {
+		bloomSkippedTotal.Add(1)
+		return nil, false
+	}
+	return b.storeBackend.Get(key)
+}
+
+func (b *bloomBackend) Set(key string, entry *Entry) {
+	b.Update(key, func(upd *entryUpdate) {
+		upd.Set(entry)
+	})
+}
+
+func (b *bloomBackend) Delete(key string) {
+	b.Update(key, func(upd *entryUpdate) {
+		upd.Delete()
+	})
+}
+
+// Update keeps the filter in step with the keys. New keys are added before they become
+// visible, removed ones only after they are gone, so the filter never misses a stored key.
+func (b *bloomBackend) Update(key string, fn func(upd *entryUpdate)) (*Entry, bool) {
+	removed := false
+	entry, exists := b.storeBackend.Update(key, func(upd *entryUpdate) {
+		existed := upd.Exists
+		fn(upd)
+		switch {
+		case !existed && upd.action == updateSet:
+			b.filter.add(key)
+		case existed && upd.action == updateDelete:
+			removed = true
+		}
+	})
+	if removed {
+		b.filter.remove(key)
+	}
+	return entry, exists
+}
@@ -1,3 +1,4 @@
 #!/usr/bin/env bash
-CGO_ENABLED=0 go build -ldflags "-s -w" && upx rendezvous-server
-CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -o rendezvous-server-arm64 -ldflags "-s -w" && upx rendezvous-server-arm64
+VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+CGO_ENABLED=0 go build -ldflags "-s -w -X main.version=$VERSION" && upx rendezvous-server
+CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -o rendezvous-server-arm64 -ldflags "-s -w -X main.version=$VERSION" && upx rendezvous-server-arm64
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// valueChecksum returns the hex encoded SHA-256 of the value
+func valueChecksum(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumMatches reports whether an X-Content-SHA256 header matches the body
+func checksumMatches(header string, body []byte) bool {
+	return strings.EqualFold(strings.TrimSpace(header), valueChecksum(body))
+}
+
+// checksum returns the SHA-256 of the entry's value, computing it for entries stored without one
+func (e *Entry) checksum() string {
+	if e.Checksum != "" {
+		return e.Checksum
+	}
+	return valueChecksum(e.value())
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// chunkUploadTimeout is how long an incomplete chunked upload is kept
+	chunkUploadTimeout = 5 * time.Minute
+	// maxChunks limits the number of chunks of a single upload
+	maxChunks = 1000
+	// maxUploadsPerClient limits incomplete chunked uploads per client
+	maxUploadsPerClient = 4
+)
+
+// chunkUpload holds the chunks of a value received so far
+type chunkUpload struct {
+	client   string
+	chunks   [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+var (
+	// chunkUploads holds incomplete uploads by client and key
+	chunkUploads = make(map[string]*chunkUpload)
+	// chunkUploadsMu protects chunkUploads
+	chunkUploadsMu sync.Mutex
+)
+
+// parseChunk parses the X-Chunk header "index/total", index starting at 1
+func parseChunk(s string) (index, total int, ok bool) {
+	indexStr, totalStr, found := strings.Cut(s, "/")
+	if !found {
+		return 0, 0, false
+	}
+	index, err1 := strconv.Atoi(strings.TrimSpace(indexStr))
+	total, err2 := strconv.Atoi(strings.TrimSpace(totalStr))
+	if err1 != nil || err2 != nil || total < 1 || total > maxChunks || index < 1 || index > total {
+		return 0, 0, false
+	}
+	return index, total, true
+}
+
+// addChunk stores a chunk of the value being uploaded to key by client.
+// Once all chunks have arrived, the upload is removed and the assembled value returned.
+func addChunk(client, key string, index, total int, data []byte) (assembled []byte, complete bool, err error) {
+	chunkUploadsMu.Lock()
+	defer chunkUploadsMu.Unlock()
+
+	// Forget uploads that were abandoned
+	now := time.Now()
+	for id, upload := range chunkUploads {
+		if now.Sub(upload.started) > chunkUploadTimeout {
+			delete(chunkUploads, id)
+		}
+	}
+
+	id := client + "\x00" + key
+	upload, exists := chunkUploads[id]
+	if !exists {
+		pending := 0
+		for _, other := range chunkUploads {
+			if other.client == client {
+				pending++
+			}
+		}
+		if pending >= maxUploadsPerClient {
+			return nil, false, errors.New("Too many incomplete chunked uploads")
+		}
+		upload = &chunkUpload{client: client, chunks: make([][]byte, total), started: now}
+	}
+	if len(upload.chunks) != total {
+		return nil, false, errors.New("Chunk total does not match the upload in progress")
+	}
+
+	// Resending a chunk replaces it
+	if previous := upload.chunks[index-1]; previous != nil {
+		upload.size -= len(previous)
+		upload.received--
+	}
+	if upload.size+len(data) > *assembledSize {
+		delete(chunkUploads, id)
+		return nil, false, fmt.Errorf("Assembled value too large: limit is %d bytes", *assembledSize)
+	}
+	upload.chunks[index-1] = append([]byte{}, data...)
+	upload.size += len(data)
+	upload.received++
+
+	if upload.received < total {
+		chunkUploads[id] = upload
+		return nil, false, nil
+	}
+	delete(chunkUploads, id)
+	assembled = make([]byte, 0, upload.size)
+	for _, chunk := range upload.chunks {
+		assembled = append(assembled, chunk...)
+	}
+	return assembled, true, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// compressValue gzips the value if -compressValues is enabled, it is large enough and compression actually helps
+func compressValue(value []byte) (stored []byte, compressed bool) {
+	if !*compressValues {
+		return value, false
+	}
+	return gzipValue(value)
+}
+
+// gzipValue gzips values of at least *compressMinSize bytes if that makes them smaller
+func gzipValue(value []byte) ([]byte, bool) {
+	if len(value) < *compressMinSize {
+		return value, false
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(value)
+	if err := gz.Close(); err != nil || buf.Len() >= len(value) {
+		return value, false
+	}
+	return buf.Bytes(), true
+}
+
+// setValue stores the value in the entry, compressing it if worthwhile, along with its checksum
+func (e *Entry) setValue(value []byte) {
+	e.Value, e.Compressed = compressValue(value)
+	e.Checksum = valueChecksum(value)
+}
+
+// value returns the original value of the entry, decompressing it if needed
+func (e *Entry) value() []byte {
+	if !e.Compressed {
+		return e.Value
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(e.Value))
+	if err != nil {
+		log.Printf("Error decompressing value: %v", err)
+		return nil
+	}
+	value, err := io.ReadAll(gz)
+	if err != nil {
+		log.Printf("Error decompressing value: %v", err)
+		return nil
+	}
+	return value
+}
+
+// acceptsGzip reports whether the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// responseValue returns the bytes to send for the entry. Compressed values are passed
+// through as is to clients accepting gzip, with Content-Encoding set accordingly.
+// With -gzipResponses, large uncompressed values are gzipped for such clients on the fly,
+// except for HEAD, which then describes the uncompressed value.
+func responseValue(w http.ResponseWriter, r *http.Request, entry *Entry) []byte {
+	if !entry.Compressed && (!*gzipResponses || len(entry.Value) < *compressMinSize) {
+		return entry.Value
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return entry.value()
+	}
+	if entry.Compressed {
+		w.Header().Set("Content-Encoding", "gzip")
+		return entry.Value
+	}
+	// There is no body to send, compressing the value only to measure it isn't worth it
+	if r.Method == http.MethodHead {
+		return entry.Value
+	}
+	if compressed, ok := gzipValue(entry.Value); ok {
+		w.Header().Set("Content-Encoding", "gzip")
+		return compressed
+	}
+	return entry.Value
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// runtimeConfig holds the settings that can be changed at runtime by sending SIGHUP.
+// Hot paths read them from currentConfig() instead of the flag variables.
+type runtimeConfig struct {
+	maxRequests         int
+	expireDuration      time.Duration
+	postCost            int
+	getCost             int
+	deleteCost          int
+	trustedProxyNets    []*net.IPNet // empty means private/loopback
+	rateLimitExemptNets []*net.IPNet
+	corsOrigins         []string
+	namespaces          []namespace // sorted by prefix length, longest first
+}
+
+var config atomic.Pointer[runtimeConfig]
+
+// currentConfig returns the active runtime configuration
+func currentConfig() *runtimeConfig {
+	return config.Load()
+}
+
+// reloadableFlags lists the flags applied from the config file on SIGHUP.
+// Everything else, such as listen addresses, requires a restart.
+var reloadableFlags = map[string]bool{
+	"maxRequests":     true,
+	"expireDuration":  true,
+	"postCost":        true,
+	"getCost":         true,
+	"deleteCost":      true,
+	"trustedProxies":  true,
+	"rateLimitExempt": true,
+	"corsOrigin":      true,
+	"namespaces":      true,
+}
+
+// buildConfig validates the reloadable flags and builds a runtime configuration from them
+func buildConfig() (*runtimeConfig, error) {
+	if *maxRequests < 1 {
+		return nil, fmt.Errorf("maxRequests must be positive")
+	}
+	if *expireDuration <= 0 {
+		return nil, fmt.Errorf("expireDuration must be positive")
+	}
+	for name, cost := range map[string]int{"postCost": *postCost, "getCost": *getCost, "deleteCost": *deleteCost} {
+		if cost < 1 || cost > *maxRequests {
+			return nil, fmt.Errorf("%s must be between 1 and maxRequests (%d)", name, *maxRequests)
+		}
+	}
+	cfg := &runtimeConfig{
+		maxRequests:    *maxRequests,
+		expireDuration: *expireDuration,
+		postCost:       *postCost,
+		getCost:        *getCost,
+		deleteCost:     *deleteCost,
+	}
+	var err error
+	if cfg.trustedProxyNets, err = parseCIDRList(*trustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trustedProxies: %v", err)
+	}
+	if cfg.rateLimitExemptNets, err = parseCIDRList(*rateLimitExempt); err != nil {
+		return nil, fmt.Errorf("invalid rateLimitExempt: %v", err)
+	}
+	for _, o := range strings.Split(*corsOrigin, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			cfg.corsOrigins = append(cfg.corsOrigins, o)
+		}
+	}
+	if cfg.namespaces, err = parseNamespaces(*namespacesFlag); err != nil {
+		return nil, fmt.Errorf("invalid namespaces: %v", err)
+	}
+	return cfg, nil
+}
+
+// readConfigFile reads flag values from a config file. The format is chosen by extension:
+// .toml and .yaml/.yml files hold a flat table of flag names, anything else is read as
+// "flag = value" lines with "#" comments.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		var raw map[string]any
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		values, err = configValues(raw)
+	case ".yaml", ".yml":
+		var raw map[string]any
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		values, err = configValues(raw)
+	default:
+		values, err = parseConfigLines(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for name := range values {
+		if name == "config" || flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s: unknown flag %q", path, name)
+		}
+	}
+	return values, nil
+}
+
+// configValues converts a decoded TOML/YAML table into flag values
+func configValues(raw map[string]any) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string, bool, int, int64, uint64, float64:
+			values[name] = fmt.Sprint(v)
+		case time.Duration:
+			values[name] = v.String()
+		default:
+			return nil, fmt.Errorf("%s must be a string, number or boolean", name)
+		}
+	}
+	return values, nil
+}
+
+// parseConfigLines parses "flag = value" lines
+func parseConfigLines(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected flag = value", lineNum)
+		}
+		values[name] = value
+	}
+	return values, scanner.Err()
+}
+
+// commandLineFlags returns the flags set on the command line, which take precedence over the config file.
+// Must be called before applying the config file, flag.Set marks flags as set too.
+func commandLineFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// applyConfigFile sets flags from the config file, except those given on the command line
+func applyConfigFile(path string, explicit map[string]bool) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// reloadConfig re-reads the config file and applies the reloadable settings.
+// On any error the running configuration is kept unchanged.
+func reloadConfig(path string, explicit map[string]bool) error {
+	if path == "" {
+		return fmt.Errorf("no -config file to reload")
+	}
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	previous := make(map[string]string)
+	restore := func() {
+		for name, value := range previous {
+			flag.Set(name, value)
+		}
+	}
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+		f := flag.Lookup(name)
+		if !reloadableFlags[name] {
+			if f.Value.String() != value {
+				log.Printf("Config: %s changed, restart to apply", name)
+			}
+			continue
+		}
+		previous[name] = f.Value.String()
+		if err := f.Value.Set(value); err != nil {
+			restore()
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	cfg, err := buildConfig()
+	if err != nil {
+		restore()
+		return err
+	}
+	config.Store(cfg)
+	return nil
+}
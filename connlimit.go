@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// activeConns is the number of currently open connections on all listeners
+var activeConns atomic.Int64
+
+// countingListener tracks open connections and closes new ones right away once *maxConns is reached
+type countingListener struct {
+	net.Listener
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if n := activeConns.Add(1); *maxConns > 0 && n > int64(*maxConns) {
+			activeConns.Add(-1)
+			connRejectedTotal.Add(1)
+			conn.Close()
+			continue
+		}
+		return &countedConn{Conn: conn}, nil
+	}
+}
+
+// countedConn releases its slot in activeConns when closed
+type countedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		activeConns.Add(-1)
+	})
+	return c.Conn.Close()
+}
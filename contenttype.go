@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+)
+
+// defaultContentType is served for values stored without X-Content-Type
+const defaultContentType = "application/octet-stream"
+
+// allowedContentTypeSet is parsed from *allowedTypes
+var allowedContentTypeSet map[string]bool
+
+// parseContentTypeList parses a comma-separated list of media types
+func parseContentTypeList(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			set[t] = true
+		}
+	}
+	return set
+}
+
+// normalizeContentType validates a client provided content type against the allowlist.
+// Types like text/html are not allowed by default, they would let values run scripts on the server's origin.
+func normalizeContentType(header string) (string, error) {
+	if len(header) > 100 {
+		return "", errors.New("X-Content-Type too long")
+	}
+	mediaType, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "", errors.New("Invalid X-Content-Type")
+	}
+	if !allowedContentTypeSet[mediaType] {
+		return "", errors.New("X-Content-Type not allowed")
+	}
+	return mime.FormatMediaType(mediaType, params), nil
+}
+
+// checkJSONValue rejects values of JSON content types that are not well-formed JSON,
+// so consumers of such keys never read garbage
+func checkJSONValue(contentType string, value []byte) error {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && !json.Valid(value) {
+		return errors.New("Invalid JSON value")
+	}
+	return nil
+}
+
+// contentType returns the content type to serve the entry with
+func (e *Entry) contentType() string {
+	if e.MediaType == "" {
+		return defaultContentType
+	}
+	return e.MediaType
+}
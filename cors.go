@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Request headers browsers are allowed to send cross-origin
+var corsAllowHeaders = []string{
+	"Content-Type",
+	"If-Match",
+	"If-None-Match",
+	"Range",
+	"X-Alias",
+	"X-Chunk",
+	"X-Content-SHA256",
+	"X-Content-Type",
+	"X-New-Owner-Secret",
+	"X-Op",
+	"X-Owner-Secret",
+	"X-Peek",
+	"X-Read-Secret",
+	"X-Set-Header",
+	"X-TTL",
+	"X-Transfer-To",
+}
+
+// Response headers readable by cross-origin scripts
+var corsExposeHeaders = []string{
+	"Content-Range",
+	"ETag",
+	"Retry-After",
+	"X-Content-SHA256",
+	"X-Creator-IP",
+	"X-Expires-In",
+	"X-Last-Update",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+}
+
+// setCORSHeaders adds CORS headers if the request origin is allowed by -corsOrigin.
+// Returns false if CORS is disabled or the origin is not allowed.
+func setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	origins := currentConfig().corsOrigins
+	if len(origins) == 0 || origin == "" {
+		return false
+	}
+	allowed := ""
+	for _, o := range origins {
+		if o == "*" {
+			allowed = "*"
+			break
+		}
+		if strings.EqualFold(o, origin) {
+			allowed = origin
+			break
+		}
+	}
+	if allowed == "" {
+		return false
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", allowed)
+	if allowed != "*" {
+		h.Add("Vary", "Origin")
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(keyMethods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(append(corsAllowHeaders, *adminHeader), ", "))
+	h.Set("Access-Control-Expose-Headers", strings.Join(corsExposeHeaders, ", "))
+	return true
+}
+
+// handlePreflight answers CORS preflight requests
+func handlePreflight(w http.ResponseWriter, r *http.Request) {
+	if setCORSHeaders(w, r) {
+		w.Header().Set("Access-Control-Max-Age", "86400")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
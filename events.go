@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// Interval between SSE comments keeping idle streams alive through proxies
+const eventsHeartbeat = 30 * time.Second
+
+// eventsHandler streams changes of a key as Server-Sent Events.
+// Every update emits an "update" event with the value ("binary" with base64 data
+// for values that are not valid UTF-8), and deletion emits a "delete" event.
+func eventsHandler(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if key == "" {
+		writeError(w, "Key is required", http.StatusBadRequest)
+		return
+	}
+	if entry, exists := getEntry(key); exists && !canRead(r, entry) {
+		writeError(w, "Forbidden: Incorrect read secret", http.StatusForbidden)
+		return
+	}
+	release, ok := acquireHold(r)
+	if !ok {
+		writeError(w, "Too many held requests", http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+
+	// Streams live indefinitely, lift the server's WriteTimeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable buffering in nginx
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	lastTag := ""
+	for {
+		// Subscribe before reading the key, so an update between the two isn't missed
+		changed, release := watchKey(key)
+		entry, exists := getEntry(key)
+		var err error
+		if exists && canRead(r, entry) {
+			if tag := entry.etag(); tag != lastTag {
+				err = writeEvent(w, entry)
+				lastTag = tag
+			}
+		} else if !exists && lastTag != "" {
+			_, err = io.WriteString(w, "event: delete\ndata:\n\n")
+			lastTag = ""
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			release()
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		case <-draining:
+			release()
+			return
+		case <-r.Context().Done():
+			release()
+			return
+		}
+		release()
+	}
+}
+
+// writeEvent writes the entry's value as a single SSE event
+func writeEvent(w io.Writer, entry *Entry) error {
+	event, data := "update", entry.value()
+	if !utf8.Valid(data) {
+		event, data = "binary", []byte(base64.StdEncoding.EncodeToString(data))
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\nid: %s\n", event, entry.etag())
+	// Multi-line values are sent as several data lines, which the client joins with "\n"
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte("\r")))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
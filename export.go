@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// exportEntry is the JSON representation of an entry used by /_export and /_import
+type exportEntry struct {
+	Value      []byte `json:"value"` // base64 encoded
+	LastUpdate int64  `json:"lastUpdate"`
+	TTL        int64  `json:"ttl,omitempty"`
+	Owned      bool   `json:"owned"`
+	Private    bool   `json:"private,omitempty"`
+	Secret     string `json:"secret,omitempty"`     // salted hash, only with -exportSecrets
+	ReadSecret string `json:"readSecret,omitempty"` // salted hash, only with -exportSecrets
+	MediaType  string `json:"contentType,omitempty"`
+	Alias      string `json:"alias,omitempty"`
+	Headers    string `json:"headers,omitempty"`
+}
+
+// exportHandler streams the whole store as a JSON object of key -> exportEntry
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="rendezvous-export.json"`)
+
+	w.Write([]byte("{"))
+	first := true
+	var err error
+	now := time.Now()
+	kvMap.Range(func(key string, entry *Entry) bool {
+		// Expired keys not yet removed would come back to life on import
+		if entry.expired(key, now) {
+			return true
+		}
+		exported := exportEntry{
+			Value:      entry.value(),
+			LastUpdate: entry.LastUpdate,
+			TTL:        entry.TTL,
+			Owned:      entry.Secret != "",
+			Private:    entry.ReadSecret != "",
+			MediaType:  entry.MediaType,
+			Alias:      entry.Alias,
+			Headers:    entry.Headers,
+		}
+		if *exportSecrets {
+			exported.Secret = entry.Secret
+			exported.ReadSecret = entry.ReadSecret
+		}
+		var keyJSON, entryJSON []byte
+		if keyJSON, err = json.Marshal(key); err != nil {
+			return false
+		}
+		if entryJSON, err = json.Marshal(exported); err != nil {
+			return false
+		}
+		if !first {
+			w.Write([]byte(","))
+		}
+		first = false
+		w.Write([]byte("\n"))
+		w.Write(keyJSON)
+		w.Write([]byte(":"))
+		_, err = w.Write(entryJSON)
+		return err == nil
+	})
+	if err != nil {
+		// Headers are already sent, the truncated JSON tells the client the export failed
+		return
+	}
+	w.Write([]byte("\n}\n"))
+}
+
+// importResult is the JSON response of /_import
+type importResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // too large, expired, over capacity or private without read secret
+	Deleted  int `json:"deleted"` // existing keys removed in replace mode
+}
+
+// importHandler restores keys from the JSON produced by /_export.
+// With ?mode=replace keys missing from the payload are deleted, the default mode merges.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "merge"
+	}
+	if mode != "merge" && mode != "replace" {
+		writeError(w, "Invalid mode, use merge or replace", http.StatusBadRequest)
+		return
+	}
+
+	// Decode the whole payload before touching the store, so a malformed
+	// backup can't leave it half-replaced. Size is bounded by the store limits.
+	limit := int64(*maxNumKV) * int64(2*largestValueSize()+1024)
+	var payload map[string]exportEntry
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(&payload); err != nil {
+		writeError(w, "Invalid import payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result importResult
+	now := time.Now()
+	for key, imported := range payload {
+		entry := &Entry{
+			Secret:     imported.Secret,
+			LastUpdate: imported.LastUpdate,
+			TTL:        imported.TTL,
+			ReadSecret: imported.ReadSecret,
+			MediaType:  imported.MediaType,
+			Alias:      imported.Alias,
+			Headers:    imported.Headers,
+		}
+		entry.setValue(imported.Value)
+		// A private value can't be served safely without its read secret
+		if imported.Private && entry.ReadSecret == "" {
+			result.Skipped++
+			continue
+		}
+		// Owned keys exported without secrets are locked with an unknown secret,
+		// so nobody can take them over until they expire
+		if imported.Owned && entry.Secret == "" {
+			entry.Secret = hashSecret(randomSecret())
+		}
+		if key == "" || len(key) > *maxKeySize || validateKey(key) != nil || isReservedKey(key) ||
+			(entry.Alias != "" && (entry.Alias == key || validateKey(entry.Alias) != nil || isReservedKey(entry.Alias))) ||
+			len(imported.Value) > max(namespaceFor(key).maxValueSize, *assembledSize) ||
+			now.Sub(time.Unix(entry.LastUpdate, 0)) > entry.expiration(key) {
+			result.Skipped++
+			continue
+		}
+		current, exists := kvMap.Get(key)
+		full, _ := namespaceFull(key)
+		if (!exists && (kvMap.Size() >= *maxNumKV || full)) || !fitsStoreBytes(current, entry) {
+			result.Skipped++
+			continue
+		}
+		setKey(key, entry)
+		notifyKey(key)
+		result.Imported++
+	}
+
+	if mode == "replace" {
+		kvMap.Range(func(key string, entry *Entry) bool {
+			if _, keep := payload[key]; !keep {
+				deleteKey(key)
+				notifyKey(key)
+				result.Deleted++
+			}
+			return true
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// randomSecret returns a random secret nobody knows
+func randomSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawStdEncoding.EncodeToString(b)
+}
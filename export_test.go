@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportStore fetches /_export with the admin token and decodes it
+func exportStore(t *testing.T) map[string]exportEntry {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/_export", nil)
+	r.Header.Set("X-Admin-Token", "t")
+	w := serve(r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: got %d %s", w.Code, w.Body)
+	}
+	var exported map[string]exportEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+		t.Fatalf("export isn't valid JSON: %v", err)
+	}
+	return exported
+}
+
+func TestExportRequiresAdminToken(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "adminToken", "t")
+	for _, token := range []string{"", "wrong"} {
+		r := httptest.NewRequest(http.MethodGet, "/_export", nil)
+		r.Header.Set("X-Admin-Token", token)
+		if w := serve(r); w.Code != http.StatusUnauthorized {
+			t.Fatalf("admin token %q: got %d, want 401", token, w.Code)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "adminToken", "t")
+	serve(post("plain", "\x00binary"))
+	r := post("owned", "o")
+	r.Header.Set("X-Owner-Secret", "s")
+	r.Header.Set("X-TTL", "1m")
+	serve(r)
+	r = post("private", "p")
+	r.Header.Set("X-Read-Secret", "r")
+	serve(r)
+	// Expired but not yet removed
+	kvMap.Set("expired", &Entry{Value: []byte("v"), LastUpdate: time.Now().Add(-3 * time.Hour).Unix()})
+
+	exported := exportStore(t)
+	if len(exported) != 3 {
+		t.Fatalf("exported %d keys, want 3", len(exported))
+	}
+	plain, _ := kvMap.Get("plain")
+	if got := exported["plain"]; string(got.Value) != "\x00binary" || got.LastUpdate != plain.LastUpdate || got.Owned {
+		t.Errorf("plain exported as %+v", got)
+	}
+	if got := exported["owned"]; !got.Owned || got.TTL != 60 || got.Secret != "" {
+		t.Errorf("owned exported as %+v", got)
+	}
+	if got := exported["private"]; !got.Private || got.ReadSecret != "" {
+		t.Errorf("private exported as %+v", got)
+	}
+}
+
+func TestExportSecrets(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "adminToken", "t")
+	setFlag(t, "exportSecrets", "true")
+	r := post("k", "v")
+	r.Header.Set("X-Owner-Secret", "s")
+	r.Header.Set("X-Read-Secret", "r")
+	serve(r)
+
+	// Only the stored hashes leave the server, never the secrets themselves
+	stored, _ := kvMap.Get("k")
+	if got := exportStore(t)["k"]; got.Secret != stored.Secret || got.ReadSecret != stored.ReadSecret || !isHashedSecret(got.Secret) {
+		t.Fatalf("exported secrets %q, %q, stored %q, %q", got.Secret, got.ReadSecret, stored.Secret, stored.ReadSecret)
+	}
+}
+
+// importStore posts payload to /_import with the admin token and decodes the result
+func importStore(t *testing.T, query, payload string) importResult {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/_import"+query, strings.NewReader(payload))
+	r.Header.Set("X-Admin-Token", "t")
+	w := serve(r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import: got %d %s", w.Code, w.Body)
+	}
+	var result importResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "adminToken", "t")
+	serve(post("plain", "v"))
+	r := post("owned", "o")
+	r.Header.Set("X-Owner-Secret", "s")
+	serve(r)
+	r = post("private", "p")
+	r.Header.Set("X-Read-Secret", "r")
+	serve(r)
+	before, _ := kvMap.Get("plain")
+	dump, _ := json.Marshal(exportStore(t))
+
+	newTestStore(t)
+	// The private key can't be restored without its read secret
+	if result := importStore(t, "", string(dump)); result != (importResult{Imported: 2, Skipped: 1}) {
+		t.Fatalf("import result %+v", result)
+	}
+	if after, _ := kvMap.Get("plain"); string(after.Value) != "v" || after.LastUpdate != before.LastUpdate {
+		t.Fatalf("plain restored as %+v, was %+v", after, before)
+	}
+	// Without the exported hash nobody knows the owner secret anymore
+	r = post("owned", "x")
+	r.Header.Set("X-Owner-Secret", "s")
+	if w := serve(r); w.Code != http.StatusForbidden {
+		t.Fatalf("POST to a restored owned key: got %d, want 403", w.Code)
+	}
+}
+
+func TestImportModes(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "adminToken", "t")
+	payload := `{"new": {"value": "dg==", "lastUpdate": ` + strconv.FormatInt(time.Now().Unix(), 10) + `, "owned": false}}`
+
+	serve(post("old", "v"))
+	if result := importStore(t, "", payload); result != (importResult{Imported: 1}) {
+		t.Fatalf("merge result %+v", result)
+	}
+	if _, exists := kvMap.Get("old"); !exists {
+		t.Fatal("merge removed an existing key")
+	}
+	if result := importStore(t, "?mode=replace", payload); result != (importResult{Imported: 1, Deleted: 1}) {
+		t.Fatalf("replace result %+v", result)
+	}
+	if _, exists := kvMap.Get("old"); exists {
+		t.Fatal("replace kept a key missing from the payload")
+	}
+}
+
+func TestImportSkipsExpired(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "adminToken", "t")
+	old := strconv.FormatInt(time.Now().Add(-3*time.Hour).Unix(), 10)
+	payload := `{"gone": {"value": "dg==", "lastUpdate": ` + old + `, "owned": false},` +
+		`"kept": {"value": "dg==", "lastUpdate": ` + old + `, "ttl": 86400, "owned": false}}`
+	if result := importStore(t, "", payload); result != (importResult{Imported: 1, Skipped: 1}) {
+		t.Fatalf("import result %+v", result)
+	}
+	if _, exists := kvMap.Get("kept"); !exists {
+		t.Fatal("key with a long TTL not imported")
+	}
+}
+
+func TestImportRejectsInvalidPayload(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "adminToken", "t")
+	serve(post("k", "v"))
+	for _, query := range []string{"?mode=replace", "?mode=wipe"} {
+		r := httptest.NewRequest(http.MethodPost, "/_import"+query, strings.NewReader(`{"k": `))
+		r.Header.Set("X-Admin-Token", "t")
+		if w := serve(r); w.Code != http.StatusBadRequest {
+			t.Fatalf("import%s: got %d, want 400", query, w.Code)
+		}
+	}
+	// Nothing is touched before the whole payload is decoded
+	if _, exists := kvMap.Get("k"); !exists {
+		t.Fatal("failed import deleted keys")
+	}
+}
@@ -2,9 +2,16 @@ module rendezvous-server
 
 go 1.23.6
 
-require github.com/Jipok/go-persist v1.9.1
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/Jipok/go-persist v1.9.1
+	golang.org/x/crypto v0.36.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/goccy/go-json v0.10.5 // indirect
 	github.com/puzpuzpuz/xsync/v3 v3.5.1 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
 )
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// maxCustomHeaderBytes caps the total size of the headers stored with a key
+const maxCustomHeaderBytes = 512
+
+// customHeaderAllowlist lists the response headers clients may store with X-Set-Header.
+// Anything that could change how browsers treat the origin, like CORS or cookies, is left out.
+var customHeaderAllowlist = map[string]bool{
+	"Cache-Control":       true,
+	"Content-Disposition": true,
+	"Content-Language":    true,
+	"Expires":             true,
+	"Link":                true,
+	"X-Robots-Tag":        true,
+}
+
+// parseCustomHeaders validates the X-Set-Header values of a request, each one "Name: value",
+// and returns them as lines in the same format. Returns "" if none were sent.
+func parseCustomHeaders(r *http.Request) (string, error) {
+	var headers []string
+	total := 0
+	for _, v := range r.Header.Values("X-Set-Header") {
+		name, value, found := strings.Cut(v, ":")
+		name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !found || value == "" {
+			return "", errors.New("Invalid X-Set-Header, expected Name: value")
+		}
+		if !customHeaderAllowlist[name] {
+			return "", errors.New("X-Set-Header " + name + " not allowed")
+		}
+		total += len(name) + len(value)
+		if total > maxCustomHeaderBytes {
+			return "", errors.New("X-Set-Header too large")
+		}
+		headers = append(headers, name+": "+value)
+	}
+	return strings.Join(headers, "\n"), nil
+}
+
+// setCustomHeaders replays the headers stored with the entry.
+// The allowlist is checked again, since imported entries bypass parseCustomHeaders.
+func setCustomHeaders(w http.ResponseWriter, entry *Entry) {
+	if entry.Headers == "" {
+		return
+	}
+	for _, line := range strings.Split(entry.Headers, "\n") {
+		if name, value, found := strings.Cut(line, ": "); found && customHeaderAllowlist[name] {
+			w.Header().Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// errorResponse is the body of error responses with -errorFormat=json
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// writeError replies with the error message and status code, as plain text or JSON depending on -errorFormat
+func writeError(w http.ResponseWriter, msg string, code int) {
+	if *errorFormat != "json" {
+		http.Error(w, msg, code)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorResponse{Error: msg, Code: code})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// interfaceAddr resolves -l given as a network interface name (e.g. eth0) to its address.
+// IPv4 addresses are preferred, link-local ones are skipped. Returns ok=false if no
+// interface has that name, so the value is used as an address as before.
+func interfaceAddr(name string) (addr string, ok bool, err error) {
+	if net.ParseIP(name) != nil {
+		return "", false, nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", false, nil
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", true, fmt.Errorf("can't get addresses of interface %s: %w", name, err)
+	}
+	var fallback net.IP
+	for _, a := range addrs {
+		ipNet, isNet := a.(*net.IPNet)
+		if !isNet || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), true, nil
+		}
+		if fallback == nil {
+			fallback = ipNet.IP
+		}
+	}
+	if fallback == nil {
+		return "", true, fmt.Errorf("interface %s has no usable address", name)
+	}
+	return fallback.String(), true, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// keyRegexp is compiled from *keyPattern, nil allows any key passing the basic checks
+var keyRegexp *regexp.Regexp
+
+// isReservedKey reports whether the key falls under *reservedPrefix, which is kept for service endpoints
+func isReservedKey(key string) bool {
+	return *reservedPrefix != "" && strings.HasPrefix(key, *reservedPrefix)
+}
+
+// validateKey rejects keys that are ambiguous or unsafe to store and log:
+// invalid UTF-8, control characters and "." or ".." path segments
+func validateKey(key string) error {
+	if !utf8.ValidString(key) {
+		return errors.New("Key is not valid UTF-8")
+	}
+	if strings.IndexFunc(key, unicode.IsControl) >= 0 {
+		return errors.New("Key contains control characters")
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return errors.New("Key contains relative path segments")
+		}
+	}
+	if keyRegexp != nil && !keyRegexp.MatchString(key) {
+		return errors.New("Key contains disallowed characters")
+	}
+	return nil
+}
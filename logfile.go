@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is an append-only log file that is rotated once it grows beyond maxSize.
+// Rotated files are renamed to path.1 (newest) up to path.keep, older ones are removed.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+// openRotatingFile opens the log file at path for appending
+func openRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if it wouldn't fit into the current file
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		// Reopening failed after the last rotation, try again
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated files by one and starts a new file
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.keep))
+	for i := f.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if f.keep > 0 {
+		os.Rename(f.path, f.path+".1")
+	} else {
+		os.Remove(f.path)
+	}
+	return f.open()
+}
+
+// Close closes the current file
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
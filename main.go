@@ -5,59 +5,235 @@ import (
 	"compress/gzip"
 	"context"
 	_ "embed"
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-
-	"github.com/Jipok/go-persist"
 )
 
 // Command-line flags for configuration
 var (
-	maxKeySize     = flag.Int("maxKeySize", 100, "maximum allowed key length in bytes")
-	maxValueSize   = flag.Int("maxValueSize", 1000, "maximum allowed value size in bytes")
-	maxNumKV       = flag.Int("maxNumKV", 100000, "maximum number of key-value pairs allowed")
-	expireDuration = flag.Duration("expireDuration", 2*time.Hour, "duration after which a key expires")
-	resetDuration  = flag.Duration("resetDuration", time.Minute, "duration between resets of the requests rate limit")
-	saveDuration   = flag.Duration("saveDuration", 30*time.Minute, "duration between automatic state saves")
-	maxRequests    = flag.Int("maxRequests", 11, "maximum request tokens per IP per resetDuration (POST=3 tokens, GET=1 token)")
-	port           = flag.String("port", "80", "port on which the server listens")
-	listen         = flag.String("l", "0.0.0.0", "interface to listen")
-	disableWarning = flag.Bool("disableLocalIPWaring", false, "disable warnings about requests from localhost")
+	maxKeySize      = flag.Int("maxKeySize", 100, "maximum allowed key length in bytes")
+	reservedPrefix  = flag.String("reservedPrefix", "_", "key prefix reserved for service endpoints, never usable for data (empty disables)")
+	keyPattern      = flag.String("keyPattern", "", "regular expression that keys must match, e.g. ^[A-Za-z0-9._:/-]+$ (empty allows any)")
+	maxValueSize    = flag.Int("maxValueSize", 1000, "maximum allowed value size in bytes")
+	maxSecretSize   = flag.Int("maxSecretSize", 256, "maximum length of X-Owner-Secret and X-Read-Secret in bytes")
+	requireSecret   = flag.Bool("requireSecret", false, "reject POSTs without X-Owner-Secret with 401, so every key has an owner")
+	requireSecretIP = flag.Bool("requireSecretIP", false, "with -requireSecret, also require a secret for keys in IP namespaces (ip/ by default)")
+	maxPostSize     = flag.Int("maxPostSize", 0, "maximum combined size of a POSTed value and its secrets in bytes (0 means no combined limit)")
+	assembledSize   = flag.Int("maxAssembledSize", 0, "maximum size of a value uploaded in chunks with X-Chunk (0 disables chunked uploads)")
+	maxStoreBytes   = flag.Int64("maxStoreBytes", 0, "maximum total size of stored values in bytes, least recently updated keys are evicted beyond it (0 means unlimited)")
+	compressValues  = flag.Bool("compressValues", false, "gzip stored values larger than compressMinSize to save memory")
+	gzipResponses   = flag.Bool("gzipResponses", false, "gzip values of at least compressMinSize bytes in responses to clients accepting gzip")
+	compressMinSize = flag.Int("compressMinSize", 256, "minimum value size in bytes to compress with -compressValues and -gzipResponses")
+	maxKeysPerIP    = flag.Int("maxKeysPerIP", 0, "maximum number of existing keys created from a single IP, stores the creator's IP with each key like -recordCreatorIP (0 means unlimited)")
+	recordCreatorIP = flag.Bool("recordCreatorIP", false, "store the creator's IP with each key and show it to the owner in X-Creator-IP")
+	maxNumKV        = flag.Int("maxNumKV", 100000, "maximum number of key-value pairs allowed")
+	bloomFilter     = flag.Bool("bloomFilter", false, "answer lookups of missing keys from an in-memory bloom filter sized for maxNumKV, skipping the store")
+	evictionPolicy  = flag.String("evictionPolicy", "reject", "what to do with new keys when maxNumKV is reached: reject or lru (evict the least recently updated key)")
+	expireDuration  = flag.Duration("expireDuration", 2*time.Hour, "duration after which a key expires")
+	resetDuration   = flag.Duration("resetDuration", time.Minute, "duration over which an exhausted request quota is fully refilled")
+	storePath       = flag.String("store", "store.db", "path of the persistent store file")
+	backend         = flag.String("backend", "persist", "storage backend: persist (store file) or memory (nothing is saved)")
+	allowEphemeral  = flag.Bool("allowEphemeral", false, "keep running in memory only if the store file can't be opened, losing data on restart")
+	backupDir       = flag.String("backupDir", "", "directory for periodic copies of the store file (empty disables backups)")
+	backupInterval  = flag.Duration("backupInterval", time.Hour, "interval between store backups")
+	backupKeep      = flag.Int("backupKeep", 24, "number of most recent store backups to keep")
+	saveDuration    = flag.Duration("saveDuration", 30*time.Minute, "duration between automatic state saves")
+	maxRequests     = flag.Int("maxRequests", 11, "request token bucket capacity per IP, refilled over resetDuration")
+	postCost        = flag.Int("postCost", 3, "request tokens consumed by a POST request")
+	getCost         = flag.Int("getCost", 1, "request tokens consumed by a GET or HEAD request")
+	deleteCost      = flag.Int("deleteCost", 3, "request tokens consumed by a DELETE request")
+	port            = flag.String("port", "80", "port on which the server listens")
+	listen          = flag.String("l", "0.0.0.0", "address or network interface name (e.g. eth0) to listen on, or unix:/path for a unix socket")
+	tlsCert         = flag.String("tlsCert", "", "path to TLS certificate file (enables HTTPS together with -tlsKey)")
+	tlsKey          = flag.String("tlsKey", "", "path to TLS private key file (enables HTTPS together with -tlsCert)")
+	autocertDomain  = flag.String("autocertDomain", "", "comma-separated hostnames to obtain Let's Encrypt certificates for (serves HTTPS on 443)")
+	autocertDir     = flag.String("autocertDir", "certs", "directory for caching Let's Encrypt certificates")
+	httpPort        = flag.String("httpPort", "", "with -tlsCert, also serve plain HTTP on this port")
+	redirectHTTPS   = flag.Bool("redirectHTTPS", false, "answer plain HTTP requests with a 301 redirect to HTTPS (with -autocertDomain or -httpPort)")
+	disableWarning  = flag.Bool("disableLocalIPWaring", false, "disable warnings about requests from localhost")
+	ipv6Prefix      = flag.Int("ipv6Prefix", 64, "prefix length used to group IPv6 clients for rate limiting")
+	maxTrackedIPs   = flag.Int("maxTrackedIPs", 100000, "maximum number of clients with rate limit state, the least recently seen are forgotten beyond it (0 means unlimited)")
+	touchOnGet      = flag.Bool("touchOnGet", false, "reset a key's expiration time on every successful GET without ?peek=1")
+	metrics         = flag.Bool("metrics", false, "expose Prometheus metrics at /metrics on the main listener")
+	exposeExpvar    = flag.Bool("expvar", false, "expose expvar variables at /debug/vars on the main and -metricsAddr listeners (always available with the admin token at /_debug/vars)")
+	metricsAddr     = flag.String("metricsAddr", "", "serve Prometheus metrics on a separate address instead (e.g. 127.0.0.1:9100)")
+	readTimeout     = flag.Duration("readTimeout", 10*time.Second, "maximum duration for reading a request including the body (0 means no limit)")
+	writeTimeout    = flag.Duration("writeTimeout", 10*time.Second, "maximum duration for writing a response, long-poll and SSE requests extend it (0 means no limit)")
+	keepAlive       = flag.Bool("keepAlive", false, "keep connections open between requests (rate limits still apply per request)")
+	shutdownTimeout = flag.Duration("shutdownTimeout", 10*time.Second, "time given to in-flight requests to finish on shutdown before connections are closed")
+	tombstoneTTL    = flag.Duration("tombstoneTTL", 0, "answer GET and HEAD of keys deleted within this time with 410 Gone instead of 404 (0 disables)")
+	idleTimeout     = flag.Duration("idleTimeout", time.Minute, "how long an idle keep-alive connection is kept open")
+	maxHeldPerIP    = flag.Int("maxHeldPerIP", 10, "maximum number of simultaneous long-poll and SSE requests per IP (0 means unlimited)")
+	maxLongPoll     = flag.Duration("maxLongPoll", time.Minute, "maximum wait accepted by long-polling GET ?wait= (0 disables long polling)")
+	namespacesFlag  = flag.String("namespaces", "ip/:ipPrefix=true", "per-prefix settings as prefix:option=value,...;... with options maxValueSize, expireDuration, ipPrefix, secret and maxNumKV")
+	allowedTypes    = flag.String("allowedContentTypes", "application/octet-stream,text/plain,application/json,image/png,image/jpeg,image/gif,image/webp", "comma-separated media types clients may set with X-Content-Type")
+	cleanupInterval = flag.Duration("cleanupInterval", 0, "interval between removals of expired keys (0 picks one from the shortest expiration in use, at most 1m)")
+	maxTTL          = flag.Duration("maxTTL", 0, "maximum per-key TTL accepted via X-TTL header (0 means expireDuration)")
+	webhookURL      = flag.String("webhookURL", "", "URL notified with a JSON POST whenever a key is updated (disabled if empty)")
+	webhookPrefix   = flag.String("webhookPrefix", "", "only keys with this prefix trigger the webhook (empty means all keys)")
+	blocklist       = flag.String("blocklist", "", "file of IP addresses and CIDRs to reject with 403, one per line, reloaded on SIGHUP")
+	keepRateLimit   = flag.Bool("persistRateLimit", false, "save rate limit state on shutdown and restore it on start, so restarts don't reset client quotas")
+	maxKeyUpdates   = flag.Int("maxKeyUpdates", 0, "maximum number of POSTs to a single key per resetDuration, regardless of client (0 means unlimited)")
+	rateLimitExempt = flag.String("rateLimitExempt", "", "comma-separated list of CIDRs exempt from rate limiting")
+	debugHeaders    = flag.Bool("debugHeaders", false, "add X-RateLimit-Limit and X-RateLimit-Remaining headers to responses")
+	logFormat       = flag.String("logFormat", "text", "request logging format: text (no per-request logs) or json (one JSON line per request)")
+	accessLog       = flag.String("accessLog", "", "file to write JSON access log lines to instead of stdout, enables the access log")
+	accessLogSize   = flag.Int64("accessLogMaxSize", 100<<20, "size in bytes after which the access log file is rotated (0 disables rotation)")
+	accessLogKeep   = flag.Int("accessLogKeep", 5, "number of rotated access log files to keep")
+	emptyPost       = flag.String("emptyPost", "store", "handling of POSTs with an empty body: store (an empty value), reject (400) or delete (the key, like DELETE)")
+	errorFormat     = flag.String("errorFormat", "text", "format of error responses: text or json")
+	logRedactKeys   = flag.Bool("logRedactKeys", false, "log only the namespace of requested keys instead of full paths")
+	corsOrigin      = flag.String("corsOrigin", "", "allowed CORS origins: \"*\" or a comma-separated list (CORS is disabled if empty)")
+	adminToken      = flag.String("adminToken", "", "token required for admin endpoints (admin API is disabled if empty)")
+	adminHeader     = flag.String("adminHeader", "X-Admin-Token", "request header carrying the admin token")
+	maxListResults  = flag.Int("maxListResults", 1000, "maximum number of keys returned by /_list")
+	exportSecrets   = flag.Bool("exportSecrets", false, "include hashed owner and read secrets in /_export for full restore")
+	maxConns        = flag.Int("maxConns", 0, "maximum number of simultaneous connections, new ones are closed immediately beyond it (0 means unlimited)")
+	socketMode      = flag.String("socketMode", "0660", "file permissions of the socket when listening on -l unix:/path")
+	proxyProtocol   = flag.Bool("proxyProtocol", false, "expect a PROXY protocol (v1 or v2) header on connections from trusted proxies")
+	aliasRedirect   = flag.Bool("aliasRedirect", false, "answer GET and HEAD of alias keys with a 302 redirect instead of serving the target's value")
+	indexFile       = flag.String("indexFile", "", "HTML file served at / instead of the built-in page (the built-in page is used if it can't be read)")
+	disableIndex    = flag.Bool("disableIndex", false, "respond 404 at / instead of serving a page")
+	robotsFile      = flag.String("robotsFile", "", "file served at /robots.txt instead of the built-in one disallowing all crawling")
+	configFile      = flag.String("config", "", "TOML, YAML or flag = value file with flag values; reloadable settings are re-read on SIGHUP")
+	trustedProxies  = flag.String("trustedProxies", "", "comma-separated list of CIDRs whose proxy headers are trusted (default: private and loopback)")
+	forwardedHop    = flag.Int("forwardedHop", 0, "take the client address from the Nth X-Forwarded-For entry counting from the right, 1 being the one added by the nearest proxy (0 means the leftmost valid entry, which the client can spoof)")
 )
 
 //go:embed index.html
 var indexHtml []byte
 var indexHtmlGz []byte
 
+// robotsTxt keeps crawlers away from the page and keys, replaced by -robotsFile
+var robotsTxt = []byte("User-agent: *\nDisallow: /\n")
+
 // Entry represents a stored key-value pair
 type Entry struct {
-	Value      []byte `json:"v"`           // stored value (can be binary)
-	Secret     string `json:"s,omitempty"` // secret for key ownership (empty if not owned)
-	LastUpdate int64  `json:"t"`           // timestamp of last update
+	Value      []byte `json:"v"`            // stored value (can be binary)
+	Secret     string `json:"s,omitempty"`  // salted hash of the secret for key ownership (empty if not owned)
+	LastUpdate int64  `json:"t"`            // timestamp of last update
+	TTL        int64  `json:"l,omitempty"`  // per-key lifetime in seconds (0 means expireDuration)
+	ReadSecret string `json:"r,omitempty"`  // salted hash of the secret required to read the key (empty if public)
+	CreatorIP  string `json:"c,omitempty"`  // address of the client that created the key (-recordCreatorIP or -maxKeysPerIP)
+	MediaType  string `json:"ct,omitempty"` // media type served on GET (empty means application/octet-stream)
+	Compressed bool   `json:"z,omitempty"`  // Value is gzip compressed (-compressValues)
+	Alias      string `json:"a,omitempty"`  // key whose value is served instead (X-Alias)
+	Headers    string `json:"h,omitempty"`  // extra response headers as "Name: value" lines (X-Set-Header)
+	Checksum   string `json:"d,omitempty"`  // hex SHA-256 of the uncompressed value, sent as X-Content-SHA256
 }
 
-var (
-	kvStore = persist.New()
-	kvMap   *persist.PersistMap[*Entry] // stores key -> *Entry.
+// etag returns a short version token of the entry, changing whenever the value changes.
+// LastUpdate is deliberately not included, otherwise -touchOnGet would change the tag on every read.
+// Compressed values are hashed as stored, compression is deterministic so the tag is still stable.
+func (e *Entry) etag() string {
+	h := fnv.New64a()
+	h.Write(e.Value)
+	return `"` + strconv.FormatUint(h.Sum64(), 36) + `"`
+}
+
+// etagMatches reports whether an If-Match/If-None-Match header value matches the entry
+func etagMatches(header string, e *Entry) bool {
+	current := e.etag()
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+// expiration returns the effective lifetime of the entry stored under key
+func (e *Entry) expiration(key string) time.Duration {
+	if e.TTL > 0 {
+		return time.Duration(e.TTL) * time.Second
+	}
+	return namespaceFor(key).expireDuration
+}
+
+// expired reports whether the entry stored under key has outlived its expiration at now
+func (e *Entry) expired(key string, now time.Time) bool {
+	return now.Sub(time.Unix(e.LastUpdate, 0)) > e.expiration(key)
+}
 
-	// rateLimit is a map storing available request per IP
-	rateLimit = make(map[[4]byte]uint8)
-	// mu protects postRateLimit
-	mu sync.RWMutex
+var (
+	kvMap storeBackend // stores key -> *Entry.
+	// storeReady is set once the store is loaded and cleared on shutdown, reported by /readyz
+	storeReady atomic.Bool
+	// ephemeral is set when data is kept in memory only, by -backend memory or when the store file couldn't be opened
+	ephemeral bool
 )
 
+// parseCIDRList parses a comma-separated list of CIDRs.
+// Bare IP addresses are accepted and treated as single-host networks.
+func parseCIDRList(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ipInNets reports whether ip belongs to any of the given networks
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrustedProxy reports whether proxy headers from the given address can be trusted
+func isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	nets := currentConfig().trustedProxyNets
+	if len(nets) == 0 {
+		return ip.IsPrivate() || ip.IsLoopback()
+	}
+	return ipInNets(ip, nets)
+}
+
 // getRealIP extracts the real client IP address and returns both the parsed IP and its string representation.
-// It only trusts proxy headers if the request originates from a private IP.
+// It only trusts proxy headers (X-Forwarded-For, then X-Real-IP) if the request originates from a trusted proxy.
 func getRealIP(r *http.Request) (net.IP, string) {
 	// Parse RemoteAddr to separate IP and port
 	remoteIPStr, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -66,16 +242,24 @@ func getRealIP(r *http.Request) (net.IP, string) {
 	}
 	remoteIP := net.ParseIP(remoteIPStr)
 
-	// Only trust proxy headers if the request came from a trusted (private) source
-	if remoteIP.IsPrivate() || remoteIP.IsLoopback() {
-		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-			// Split by comma and take the first valid IP candidate
-			ips := strings.Split(xff, ",")
-			for _, ipCandidate := range ips {
-				ipCandidate = strings.TrimSpace(ipCandidate)
-				if parsedIP := net.ParseIP(ipCandidate); parsedIP != nil {
-					return parsedIP, ipCandidate
-				}
+	// Unix socket peers have no address, they are local processes (typically a reverse proxy)
+	// and are treated as trusted loopback. With -proxyProtocol the client address is known instead.
+	fromUnixSocket := remoteIP == nil && r.Context().Value(unixSocketContextKey{}) != nil
+	if fromUnixSocket {
+		remoteIP, remoteIPStr = net.IPv4(127, 0, 0, 1), "127.0.0.1"
+	}
+
+	// Only trust proxy headers if the request came from a trusted source
+	if fromUnixSocket || isTrustedProxy(remoteIP) {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			if parsedIP, ipStr := forwardedIP(strings.Join(xff, ",")); parsedIP != nil {
+				return parsedIP, ipStr
+			}
+		}
+		// Fall back to X-Real-IP, which some proxies send instead of X-Forwarded-For
+		if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+			if parsedIP := net.ParseIP(xri); parsedIP != nil {
+				return parsedIP, xri
 			}
 		}
 	}
@@ -89,16 +273,65 @@ func getRealIP(r *http.Request) (net.IP, string) {
 		fmt.Printf("WARNING: Request from localhost IP (%s). This may indicate incorrectly configured proxy.\n", remoteIPStr)
 		fmt.Printf("Request: %s %s\n", r.Method, r.URL.Path)
 		fmt.Printf("Referer: %s\nUser-Agent: %s\n", referrer, ua)
-		fmt.Printf("Proxy headers:\n  X-Forwarded-For: %s\n  X-Real-IP(not supported): %s\n", forwarded, realIP)
+		fmt.Printf("Proxy headers:\n  X-Forwarded-For: %s\n  X-Real-IP: %s\n", forwarded, realIP)
 	}
 
 	return remoteIP, remoteIPStr
 }
 
+// maxForwardedHops bounds how many X-Forwarded-For entries are looked at
+const maxForwardedHops = 32
+
+// forwardedIP picks the client address from an X-Forwarded-For list according to -forwardedHop.
+// Entries are split off one at a time, so a huge header doesn't cost more than maxForwardedHops.
+func forwardedIP(xff string) (net.IP, string) {
+	if *forwardedHop <= 0 {
+		// The first valid IP candidate
+		for hop := 0; hop < maxForwardedHops && xff != ""; hop++ {
+			candidate, rest, _ := strings.Cut(xff, ",")
+			candidate = strings.TrimSpace(candidate)
+			if parsedIP := net.ParseIP(candidate); parsedIP != nil {
+				return parsedIP, candidate
+			}
+			xff = rest
+		}
+		return nil, ""
+	}
+	// Entries to the left of the chosen hop were added before reaching a trusted proxy and are ignored
+	for hop := 1; ; hop++ {
+		i := strings.LastIndexByte(xff, ',')
+		if hop == *forwardedHop {
+			candidate := strings.TrimSpace(xff[i+1:])
+			if parsedIP := net.ParseIP(candidate); parsedIP != nil {
+				return parsedIP, candidate
+			}
+			return nil, ""
+		}
+		if i < 0 {
+			return nil, ""
+		}
+		xff = xff[:i]
+	}
+}
+
 func mainHandler(w http.ResponseWriter, r *http.Request) {
-	// Serve embedded index.html for the root path
+	countRequest(r.Method)
+
+	// CORS preflight requests don't consume rate limit tokens
+	if r.Method == http.MethodOptions {
+		handlePreflight(w, r)
+		return
+	}
+	setCORSHeaders(w, r)
+
+	// Serve embedded index.html (or -indexFile) for the root path
 	if r.URL.Path == "/" {
-		if r.Method != http.MethodGet {
+		if *disableIndex {
+			writeError(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
 			return
 		}
 		w.Header().Set("Content-Encoding", "gzip")
@@ -107,16 +340,49 @@ func mainHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Service endpoints bypass rate limiting and key handling
+	switch r.URL.Path {
+	case "/metrics":
+		if *metrics {
+			metricsHandler(w, r)
+			return
+		}
+	case "/debug/vars":
+		if *exposeExpvar {
+			expvarHandler(w, r)
+			return
+		}
+	case "/healthz":
+		w.Write([]byte("OK"))
+		return
+	case "/robots.txt":
+		// Crawlers shouldn't spend rate limit tokens probing random keys
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(robotsTxt)
+		return
+	case "/readyz":
+		if !storeReady.Load() {
+			writeError(w, "Not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+		return
+	}
+
 	// Safely extract key from URL path
 	key := strings.TrimPrefix(r.URL.Path, "/")
 	if key == "" {
-		http.Error(w, "Key is required", http.StatusBadRequest)
+		writeError(w, "Key is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check key length limit
 	if len(key) > *maxKeySize {
-		http.Error(w, "Key too long", http.StatusBadRequest)
+		writeError(w, "Key too long", http.StatusBadRequest)
+		return
+	}
+	if err := validateKey(key); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -125,154 +391,844 @@ func mainHandler(w http.ResponseWriter, r *http.Request) {
 	if parsedIP == nil {
 		return // Invalid IP format
 	}
-	ip4 := parsedIP.To4()
-	if ip4 == nil {
-		http.Error(w, "Only IPv4 is supported", http.StatusBadRequest)
+	if isBlocked(parsedIP) {
+		writeError(w, "Forbidden", http.StatusForbidden)
 		return
 	}
-	var ipKey [4]byte
-	copy(ipKey[:], ip4)
+	ipKey := rateLimitKey(parsedIP)
+	r = r.WithContext(context.WithValue(r.Context(), clientKeyContextKey{}, ipKey))
 
-	// Rate limiting
-	mu.Lock()
-	// If no requests registered for this IP, assume default
-	availableTokens, exists := rateLimit[ipKey]
-	if !exists {
-		availableTokens = uint8(*maxRequests)
-	}
-	if r.Method == http.MethodPost {
-		// Check if there are at least 3 tokens for a POST request
-		if availableTokens < 3 {
-			mu.Unlock()
-			http.Error(w, "Rate limit", http.StatusTooManyRequests)
+	// Rate limiting, skipped for exempt clients
+	if !ipInNets(parsedIP, currentConfig().rateLimitExemptNets) {
+		ok, remaining, wait := takeTokens(ipKey, requestCost(r.Method))
+		annotateAccessLog(w, stringIP, remaining)
+		if *debugHeaders {
+			setRateLimitHeaders(w, remaining)
+		}
+		if !ok {
+			rateLimitedTotal.Add(1)
+			setRetryAfter(w, wait)
+			writeError(w, "Rate limit", http.StatusTooManyRequests)
 			return
 		}
-		availableTokens -= 3
 	} else {
-		// Check if there is at least 1 token for a GET request
-		if availableTokens < 1 {
-			mu.Unlock()
-			http.Error(w, "Rate limit", http.StatusTooManyRequests)
+		annotateAccessLog(w, stringIP, -1)
+	}
+
+	// Admin endpoints are dispatched after rate limiting to slow down token guessing
+	if handler, ok := adminRoutes[r.URL.Path]; ok {
+		if checkAdmin(w, r) {
+			handler(w, r)
+		}
+		return
+	}
+
+	// Echo the client's address as seen by the server, like the response of ip/ keys
+	if key == "_ip" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
 			return
 		}
-		availableTokens -= 1
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(stringIP))
+		return
+	}
+
+	// Server-Sent Events stream of key changes
+	if eventsKey, ok := strings.CutPrefix(key, "_events/"); ok {
+		eventsHandler(w, r, eventsKey)
+		return
+	}
+
+	// Service endpoints are dispatched above, so stored keys can never shadow them
+	if isReservedKey(key) {
+		writeError(w, "Key prefix "+*reservedPrefix+" is reserved", http.StatusBadRequest)
+		return
 	}
-	// Update the requests counter for this IP
-	rateLimit[ipKey] = availableTokens
-	mu.Unlock()
 
-	// Special handling for /ip/ paths in POST requests
-	if len(key) > 3 && key[:3] == "ip/" && r.Method == http.MethodPost {
-		remainder := key[3:] // part after "ip/"
-		// Automatically prefix POST keys with client's IP
-		key = "ip/" + stringIP + "/" + remainder
+	// Automatically prefix POST keys in IP namespaces (ip/ by default) with client's IP
+	if ns := namespaceFor(key); ns.ipPrefix && len(key) > len(ns.prefix) && r.Method == http.MethodPost {
+		r = r.WithContext(context.WithValue(r.Context(), requestedKeyContextKey{}, key))
+		key = ns.prefix + stringIP + "/" + key[len(ns.prefix):]
 	}
 
 	handleKeyRequest(w, r, key)
 }
 
-// handleKeyRequest processes GET and POST for a specific key
+// handleKeyRequest processes GET, HEAD, POST and DELETE for a specific key
 func handleKeyRequest(w http.ResponseWriter, r *http.Request, key string) {
+	// Don't start work for requests that were abandoned while queued or rate limited
+	if r.Context().Err() != nil {
+		return
+	}
+	ns := namespaceFor(key)
 	switch r.Method {
 	case http.MethodPost:
+		clientIP, clientIPStr := getRealIP(r)
 		authSecret := r.Header.Get("X-Owner-Secret")
-		// Check that the secret alone does not exceed maxValueSize
-		if len(authSecret) > *maxValueSize {
-			http.Error(w, "Value plus secret too large", http.StatusBadRequest)
+		// Optional secret making the key private
+		readSecret := r.Header.Get("X-Read-Secret")
+		// Optional replacement of the owner secret, the current one must match
+		newSecret := r.Header.Get("X-New-Owner-Secret")
+		// Optional handoff of the key to another owner, given their secret or its hash
+		transferTo := r.Header.Get("X-Transfer-To")
+		// Secrets are stored hashed, so they are limited separately from the value
+		if len(authSecret) > *maxSecretSize || len(readSecret) > *maxSecretSize ||
+			len(newSecret) > *maxSecretSize || len(transferTo) > *maxSecretSize {
+			writeError(w, "Secret too large", http.StatusBadRequest)
+			return
+		}
+		// Owner secret hash stored by a rotation or transfer, empty if the owner doesn't change
+		var newOwnerHash string
+		switch {
+		case newSecret != "" && transferTo != "":
+			writeError(w, "X-New-Owner-Secret and X-Transfer-To can't be combined", http.StatusBadRequest)
+			return
+		case newSecret != "":
+			newOwnerHash = hashSecret(newSecret)
+		case isHashedSecret(transferTo):
+			if !validSecretHash(transferTo) {
+				writeError(w, "Invalid X-Transfer-To hash", http.StatusBadRequest)
+				return
+			}
+			newOwnerHash = transferTo
+		case transferTo != "":
+			newOwnerHash = hashSecret(transferTo)
+		}
+		// Keys in IP namespaces are already tied to the client's address, so they are exempt by default
+		if *requireSecret && authSecret == "" && (!ns.ipPrefix || *requireSecretIP) {
+			writeError(w, "Unauthorized: X-Owner-Secret required", http.StatusUnauthorized)
+			return
+		}
+		// Keys in a namespace with a shared secret belong to whoever knows it,
+		// per-key secrets are neither checked nor registered there
+		nsOwned := ns.secret != ""
+		if nsOwned {
+			if !checkSecret(ns.secret, authSecret) {
+				writeError(w, "Forbidden: Incorrect namespace secret", http.StatusForbidden)
+				return
+			}
+			authSecret = ""
+		}
+		op := strings.ToLower(r.Header.Get("X-Op"))
+		if !validOp(op) {
+			writeError(w, "Unsupported X-Op", http.StatusBadRequest)
 			return
 		}
-		// Calculate the maximum allowed length for the value after taking the secret into account
-		allowedValueSize := *maxValueSize - len(authSecret)
-		// Read the value from the request body with the adjusted limit
-		body, err := io.ReadAll(io.LimitReader(r.Body, int64(allowedValueSize)+1))
+
+		var readSecretHash string
+		if readSecret != "" {
+			readSecretHash = hashSecret(readSecret)
+		}
+
+		// Optional media type returned on GET
+		var contentType string
+		var err error
+		if ctHeader := r.Header.Get("X-Content-Type"); ctHeader != "" {
+			if contentType, err = normalizeContentType(ctHeader); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Optional extra response headers returned on GET
+		headers, err := parseCustomHeaders(r)
 		if err != nil {
-			http.Error(w, "Error reading body", http.StatusInternalServerError)
+			writeError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if len(body) > allowedValueSize {
-			if authSecret != "" {
-				http.Error(w, "Value plus secret too large", http.StatusBadRequest)
+
+		// Optional per-key lifetime
+		var ttl int64
+		if ttlHeader := r.Header.Get("X-TTL"); ttlHeader != "" {
+			d, err := time.ParseDuration(ttlHeader)
+			if err != nil || d < time.Second {
+				writeError(w, "Invalid X-TTL", http.StatusBadRequest)
+				return
+			}
+			limit := *maxTTL
+			if limit <= 0 {
+				limit = ns.expireDuration
+			}
+			if d > limit {
+				writeError(w, "X-TTL exceeds maximum of "+limit.String(), http.StatusBadRequest)
+				return
+			}
+			ttl = int64(d / time.Second)
+		}
+
+		// Optional alias, GETs of the key serve another key instead
+		alias := r.Header.Get("X-Alias")
+		if alias != "" {
+			if op != opSet || r.Header.Get("X-Chunk") != "" {
+				writeError(w, "X-Alias can't be combined with X-Op or X-Chunk", http.StatusBadRequest)
+				return
+			}
+			if r.ContentLength > 0 {
+				writeError(w, "X-Alias requires an empty body", http.StatusBadRequest)
+				return
+			}
+			if err := validateAlias(key, alias); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Chunked upload of a value larger than maxValueSize, stored once the last chunk arrives
+		chunkHeader := r.Header.Get("X-Chunk")
+		var chunkIndex, chunkTotal int
+		if chunkHeader != "" {
+			if *assembledSize <= 0 {
+				writeError(w, "Chunked uploads are disabled", http.StatusBadRequest)
+				return
+			}
+			if op != opSet {
+				writeError(w, "X-Chunk can't be combined with X-Op", http.StatusBadRequest)
+				return
+			}
+			var ok bool
+			if chunkIndex, chunkTotal, ok = parseChunk(chunkHeader); !ok {
+				writeError(w, "Invalid X-Chunk", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Reject whatever can be decided from the headers before reading the body,
+		// so clients sending Expect: 100-continue don't upload a value that is refused anyway.
+		// The checks are repeated atomically during the update.
+		current, exists := kvMap.Get(key)
+		exists = exists && !current.expired(key, time.Now())
+		if exists {
+			if !nsOwned && current.Secret != "" && !checkSecret(current.Secret, authSecret) {
+				writeError(w, "Forbidden: Incorrect secret", http.StatusForbidden)
+				return
+			}
+			// Matching ETags would tell whether the value equals a guess, so only readers may compare
+			if hasPreconditions(r) && !canRead(r, current) {
+				writeError(w, "Forbidden: Incorrect read secret", http.StatusForbidden)
+				return
+			}
+			// Increments and appends build on the current value, an increment answers with the new one
+			// and an empty append would keep it for the caller to read, so only readers may apply them
+			if op != opSet && !canRead(r, current) {
+				writeError(w, "Forbidden: Incorrect read secret", http.StatusForbidden)
+				return
+			}
+			// Only owners may replace the read secret, anyone else could lock the readers out and read the value themselves
+			if readSecret != "" && !nsOwned && current.Secret == "" && !canRead(r, current) {
+				writeError(w, "Forbidden: Incorrect read secret", http.StatusForbidden)
+				return
+			}
+			if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, current) {
+				// Readers can retry against the current version, like after a failed update
+				w.Header().Set("ETag", current.etag())
+				writeError(w, "Precondition failed: Key exists", http.StatusPreconditionFailed)
+				return
+			}
+		}
+		if newOwnerHash != "" && (!exists || current.Secret == "") {
+			writeError(w, "Changing the owner requires an owned key", http.StatusBadRequest)
+			return
+		}
+
+		allowedValueSize := ns.maxValueSize
+		combinedLimit := false
+		secretsSize := len(authSecret) + len(readSecret) + len(newSecret) + len(transferTo)
+		if *maxPostSize > 0 && *maxPostSize-secretsSize < allowedValueSize {
+			allowedValueSize = max(*maxPostSize-secretsSize, 0)
+			combinedLimit = true
+		}
+		var body []byte
+		if r.ContentLength <= int64(allowedValueSize) {
+			body, err = io.ReadAll(io.LimitReader(r.Body, int64(allowedValueSize)+1))
+			if err != nil {
+				writeError(w, "Error reading body", http.StatusInternalServerError)
+				return
+			}
+		}
+		if r.ContentLength > int64(allowedValueSize) || len(body) > allowedValueSize {
+			if combinedLimit {
+				writeError(w, fmt.Sprintf("Value plus secrets too large: limit is %d bytes", *maxPostSize), http.StatusBadRequest)
 			} else {
-				http.Error(w, "Value too large", http.StatusBadRequest)
+				writeError(w, fmt.Sprintf("Value too large: limit is %d bytes", allowedValueSize), http.StatusBadRequest)
+			}
+			return
+		}
+
+		if chunkHeader != "" {
+			assembled, complete, err := addChunk(clientIPStr, key, chunkIndex, chunkTotal, body)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !complete {
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte("Chunk received"))
+				return
+			}
+			body = assembled
+			allowedValueSize = *assembledSize
+		}
+
+		// End-to-end integrity, the whole value for chunked uploads
+		if sum := r.Header.Get("X-Content-SHA256"); sum != "" && !checksumMatches(sum, body) {
+			writeError(w, "X-Content-SHA256 mismatch", http.StatusBadRequest)
+			return
+		}
+
+		// Per-key limit against many clients hammering the same key, chunks count as one update
+		if *maxKeyUpdates > 0 {
+			// The key as requested, otherwise every client of an IP namespace would get its own bucket
+			limitKey := key
+			if requested, ok := r.Context().Value(requestedKeyContextKey{}).(string); ok {
+				limitKey = requested
+			}
+			if ok, wait := takeKeyToken(limitKey); !ok {
+				rateLimitedTotal.Add(1)
+				setRetryAfter(w, wait)
+				writeError(w, "Key rate limit", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		// A rotation or transfer without a value only changes the owner,
+		// keeping the value, its lifetime and headers
+		ownerOnly := newOwnerHash != "" && len(body) == 0 && chunkHeader == "" && alias == "" && op != opIncrement
+
+		// Empty values are stored as is, rejected or delete the key, depending on -emptyPost
+		if len(body) == 0 && op == opSet && alias == "" && !ownerOnly && *emptyPost != "store" {
+			if *emptyPost == "reject" {
+				writeError(w, "Empty value", http.StatusBadRequest)
+				return
+			}
+			deleteRequest(w, r, key, ns)
+			return
+		}
+
+		// Make room within the byte budget before the update, appends may grow the current value
+		needed := int64(len(body))
+		if op == opAppend {
+			if current, exists := kvMap.Get(key); exists {
+				needed += valueSize(current)
+			}
+		}
+		// Eviction is global, so a full namespace is refused before making room
+		if _, stored := kvMap.Get(key); !stored {
+			if full, limit := namespaceFull(key); full {
+				capacityRejectedTotal.Add(1)
+				setRetryAfter(w, capacityRetryAfter())
+				writeError(w, namespaceCapacityMessage(limit), http.StatusInsufficientStorage)
+				return
+			}
+		}
+		ctx := r.Context()
+		if !ensureKeySlot(ctx, key) {
+			if ctx.Err() != nil {
+				return // Client went away
+			}
+			capacityRejectedTotal.Add(1)
+			setRetryAfter(w, capacityRetryAfter())
+			writeError(w, keyCapacityMessage(), http.StatusInsufficientStorage)
+			return
+		}
+		if !ensureStoreBytes(ctx, key, needed) {
+			if ctx.Err() != nil {
+				return // Client went away
 			}
+			capacityRejectedTotal.Add(1)
+			setRetryAfter(w, capacityRetryAfter())
+			writeError(w, byteCapacityMessage(), http.StatusInsufficientStorage)
 			return
 		}
 
+		ifMatch := r.Header.Get("If-Match")
+		// If-None-Match: * creates the key only if it doesn't exist yet
+		ifNoneMatch := r.Header.Get("If-None-Match")
 		now := time.Now()
-		if entry, exists := kvMap.Get(key); exists {
+		// Errors detected inside the atomic update are reported after it completes
+		var failStatus int
+		var failMsg string
+		entry, _ := kvMap.Update(key, func(upd *entryUpdate) {
+			fail := func(status int, msg string) {
+				failStatus, failMsg = status, msg
+				upd.Cancel()
+			}
+			// An expired entry not yet removed is replaced as if the key didn't exist
+			var expired *Entry
+			if upd.Exists && upd.Value.expired(key, now) {
+				expired = upd.Value
+			}
+			if !upd.Exists || expired != nil {
+				// Compare-and-swap requires an existing value to compare against
+				if ifMatch != "" {
+					fail(http.StatusPreconditionFailed, "Precondition failed: Key not found")
+					return
+				}
+				if newOwnerHash != "" {
+					fail(http.StatusBadRequest, "Changing the owner requires an owned key")
+					return
+				}
+				if !upd.Exists && kvMap.Size() >= *maxNumKV {
+					capacityRejectedTotal.Add(1)
+					fail(http.StatusInsufficientStorage, keyCapacityMessage())
+					return
+				}
+				if full, limit := namespaceFull(key); !upd.Exists && full {
+					capacityRejectedTotal.Add(1)
+					fail(http.StatusInsufficientStorage, namespaceCapacityMessage(limit))
+					return
+				}
+				value, err := applyOp(op, nil, body, allowedValueSize)
+				// Alias keys have no value of their own
+				if err == nil && alias == "" {
+					err = checkJSONValue(contentType, value)
+				}
+				if err != nil {
+					fail(http.StatusBadRequest, err.Error())
+					return
+				}
+				if keyQuotaReached(clientIP) {
+					fail(http.StatusTooManyRequests, "Key quota exceeded")
+					return
+				}
+				var secretHash string
+				if authSecret != "" {
+					secretHash = hashSecret(authSecret)
+				}
+				created := &Entry{
+					Secret:     secretHash,
+					LastUpdate: now.Unix(),
+					TTL:        ttl,
+					ReadSecret: readSecretHash,
+					MediaType:  contentType,
+					Alias:      alias,
+					Headers:    headers,
+				}
+				created.setValue(value)
+				if *recordCreatorIP || *maxKeysPerIP > 0 {
+					created.CreatorIP = clientIPStr
+				}
+				// Concurrent writers may have used up the room made before the update
+				if !fitsStoreBytes(expired, created) {
+					capacityRejectedTotal.Add(1)
+					fail(http.StatusInsufficientStorage, byteCapacityMessage())
+					return
+				}
+				trackEntry(key, expired, created)
+				upd.Set(created)
+				return
+			}
 			// If the key is owned (non-empty secret) then the provided secret must match
-			if entry.Secret != "" && entry.Secret != authSecret {
-				http.Error(w, "Forbidden: Incorrect secret", http.StatusForbidden)
+			if !nsOwned && upd.Value.Secret != "" && !checkSecret(upd.Value.Secret, authSecret) {
+				fail(http.StatusForbidden, "Forbidden: Incorrect secret")
+				return
+			}
+			if hasPreconditions(r) && !canRead(r, upd.Value) {
+				fail(http.StatusForbidden, "Forbidden: Incorrect read secret")
 				return
 			}
-			// If the key is not yet owned and the client provides a secret, register it
-			if entry.Secret == "" && authSecret != "" {
-				entry.Secret = authSecret
+			if op != opSet && !canRead(r, upd.Value) {
+				fail(http.StatusForbidden, "Forbidden: Incorrect read secret")
+				return
 			}
-			entry.Value = body
-			entry.LastUpdate = now.Unix()
-		} else {
-			if kvMap.Size() >= *maxNumKV {
-				http.Error(w, "Store capacity reached", http.StatusInsufficientStorage)
+			if readSecret != "" && !nsOwned && upd.Value.Secret == "" && !canRead(r, upd.Value) {
+				fail(http.StatusForbidden, "Forbidden: Incorrect read secret")
 				return
 			}
-			kvMap.SetAsync(key, &Entry{
-				Value:      body,
-				Secret:     authSecret,
-				LastUpdate: now.Unix(),
-			})
+			if ifNoneMatch != "" && etagMatches(ifNoneMatch, upd.Value) {
+				fail(http.StatusPreconditionFailed, "Precondition failed: Key exists")
+				return
+			}
+			if newOwnerHash != "" && upd.Value.Secret == "" {
+				fail(http.StatusBadRequest, "Changing the owner requires an owned key")
+				return
+			}
+			if ifMatch != "" && !etagMatches(ifMatch, upd.Value) {
+				fail(http.StatusPreconditionFailed, "Precondition failed: Value has changed")
+				return
+			}
+			// An unowned key belongs to whoever wrote it last, so it moves to the writer's quota
+			takeOver := upd.Value.Secret == "" && (*recordCreatorIP || *maxKeysPerIP > 0) && !sameCreator(upd.Value, clientIP)
+			if takeOver && keyQuotaReached(clientIP) {
+				fail(http.StatusTooManyRequests, "Key quota exceeded")
+				return
+			}
+			updated := *upd.Value
+			if !ownerOnly {
+				value, err := applyOp(op, upd.Value, body, allowedValueSize)
+				if err == nil && alias == "" {
+					// The stored type applies when the update doesn't set one, appends must keep the value valid
+					valueType := upd.Value.MediaType
+					if contentType != "" {
+						valueType = contentType
+					}
+					err = checkJSONValue(valueType, value)
+				}
+				if err != nil {
+					fail(http.StatusBadRequest, err.Error())
+					return
+				}
+				updated.setValue(value)
+				updated.LastUpdate = now.Unix()
+				updated.TTL = ttl
+				// A POST without X-Alias turns an alias back into a plain value
+				updated.Alias = alias
+			}
+			// If the key is not yet owned and the client provides a secret, register it.
+			// Plaintext secrets left by older versions are upgraded to a hash.
+			if !nsOwned && ((updated.Secret == "" && authSecret != "") || (updated.Secret != "" && !isHashedSecret(updated.Secret))) {
+				updated.Secret = hashSecret(authSecret)
+			}
+			if takeOver {
+				updated.CreatorIP = clientIPStr
+			}
+			// Rotation or transfer, the current secret was checked above, so the old one stops working
+			if newOwnerHash != "" {
+				updated.Secret = newOwnerHash
+			}
+			// Privacy is kept on updates unless a new read secret is provided
+			if readSecretHash != "" {
+				updated.ReadSecret = readSecretHash
+			}
+			// Same for the content type
+			if contentType != "" {
+				updated.MediaType = contentType
+			}
+			if headers != "" {
+				updated.Headers = headers
+			}
+			if !fitsStoreBytes(upd.Value, &updated) {
+				capacityRejectedTotal.Add(1)
+				fail(http.StatusInsufficientStorage, byteCapacityMessage())
+				return
+			}
+			trackEntry(key, upd.Value, &updated)
+			upd.Set(&updated)
+		})
+		if failStatus != 0 {
+			if failStatus == http.StatusPreconditionFailed && entry != nil && canRead(r, entry) {
+				// Let the client retry its read-modify-write against the current version
+				w.Header().Set("ETag", entry.etag())
+			}
+			if failStatus == http.StatusInsufficientStorage {
+				setRetryAfter(w, capacityRetryAfter())
+			}
+			writeError(w, failMsg, failStatus)
+			return
 		}
+		w.Header().Set("ETag", entry.etag())
+		if transferTo != "" {
+			log.Printf("Key %q transferred to a new owner by %s", key, clientIPStr)
+		}
+		clearTombstone(key)
+		notifyKey(key)
+		notifyWebhook(key, entry)
 
-		// For ip keys, return client's IP address in the response instead of "OK"
-		if strings.HasPrefix(key, "ip/") {
-			_, ipStr := getRealIP(r)
-			w.Write([]byte(ipStr))
+		// Counters respond with the new value, ip keys with client's IP address instead of "OK"
+		if op == opIncrement {
+			w.Write(entry.value())
+		} else if ns.ipPrefix {
+			w.Write([]byte(clientIPStr))
 		} else {
 			w.Write([]byte("OK"))
 		}
 
-	case http.MethodGet:
-		entry, exists := kvMap.Get(key)
+	// HEAD lets polling clients check for changes without downloading the value,
+	// it goes through the same checks and the server leaves out the body
+	case http.MethodGet, http.MethodHead:
+		entry, exists := getEntry(key)
+		if exists && entry.Alias != "" {
+			if !canRead(r, entry) {
+				writeError(w, "Forbidden: Incorrect read secret", http.StatusForbidden)
+				return
+			}
+			if *aliasRedirect {
+				redirectAlias(w, r, entry)
+				return
+			}
+			// Everything below, long polling included, works on the target key
+			key, entry, exists = resolveAlias(entry)
+		}
+
+		// Long polling, hold the request until the key appears or changes
+		if waitParam := r.URL.Query().Get("wait"); waitParam != "" {
+			if *maxLongPoll <= 0 {
+				writeError(w, "Long polling is disabled", http.StatusBadRequest)
+				return
+			}
+			wait, ok := parseWait(waitParam)
+			if !ok {
+				writeError(w, "Invalid wait", http.StatusBadRequest)
+				return
+			}
+			wait = min(wait, *maxLongPoll)
+			release, ok := acquireHold(r)
+			if !ok {
+				writeError(w, "Too many held requests", http.StatusTooManyRequests)
+				return
+			}
+			defer release()
+			if entry, exists, ok = waitForChange(w, r, key, wait); !ok {
+				return // Client went away
+			}
+			if !exists {
+				// Nothing appeared during the wait
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
 		if !exists {
-			http.Error(w, "Key not found", http.StatusNotFound)
+			// get-or-default, the client's fallback value instead of 404
+			if defaultParam, ok := r.URL.Query()["default"]; ok {
+				serveDefault(w, defaultParam[0], ns.maxValueSize)
+				return
+			}
+			if recentlyDeleted(key) {
+				writeError(w, "Key deleted", http.StatusGone)
+				return
+			}
+			writeError(w, "Key not found", http.StatusNotFound)
+			return
+		}
+
+		if !canRead(r, entry) {
+			writeError(w, "Forbidden: Incorrect read secret", http.StatusForbidden)
+			return
+		}
+
+		if *touchOnGet && r.Method == http.MethodGet && !isPeek(r) {
+			entry = touchEntry(key, entry)
+		}
+
+		// Conditional GET, the client already has the current value
+		if notModified(r, entry) {
+			setEntryHeaders(w, r, key, entry)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		// Byte ranges refer to the stored value, so they are never served gzip encoded
+		var value []byte
+		if r.Header.Get("Range") != "" {
+			value = entry.value()
+		} else {
+			value = responseValue(w, r, entry)
+		}
+		setEntryHeaders(w, r, key, entry)
+		w.Header().Set("Content-Type", entry.contentType())
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// Handles Range (206 or 416) and sets Accept-Ranges
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(value))
+
+	case http.MethodDelete:
+		deleteRequest(w, r, key, ns)
+
+	default:
+		methodNotAllowed(w, keyMethods...)
+	}
+}
+
+// deleteRequest deletes the key for a DELETE, or an empty POST with -emptyPost delete,
+// which must be authorized the same way
+func deleteRequest(w http.ResponseWriter, r *http.Request, key string, ns namespace) {
+	entry, exists := getEntry(key)
+	if !exists {
+		writeError(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case ns.secret != "":
+		// The namespace secret overrides per-key ownership
+		if !checkSecret(ns.secret, r.Header.Get("X-Owner-Secret")) {
+			writeError(w, "Forbidden: Incorrect namespace secret", http.StatusForbidden)
+			return
+		}
+	case entry.Secret == "":
+		// Unowned keys can only be deleted from the address that created them, if it was recorded
+		if _, clientIPStr := getRealIP(r); entry.CreatorIP == "" || entry.CreatorIP != clientIPStr {
+			writeError(w, "Forbidden: Key is not owned", http.StatusForbidden)
+			return
+		}
+	case !checkSecret(entry.Secret, r.Header.Get("X-Owner-Secret")):
+		writeError(w, "Forbidden: Incorrect secret", http.StatusForbidden)
+		return
+	}
+	deleteKey(key)
+	addTombstone(key)
+	notifyKey(key)
+	w.Write([]byte("OK"))
+}
+
+// keyMethods are the methods supported on keys
+var keyMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodDelete, http.MethodOptions}
+
+// methodNotAllowed responds with 405 and the Allow header listing the supported methods
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// hasPreconditions reports whether the request makes a write conditional on the current ETag
+func hasPreconditions(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != ""
+}
+
+// canRead reports whether the request is allowed to read the entry,
+// private entries require a matching X-Read-Secret header
+func canRead(r *http.Request, entry *Entry) bool {
+	return entry.ReadSecret == "" || checkSecret(entry.ReadSecret, r.Header.Get("X-Read-Secret"))
+}
+
+// serveDefault answers a GET of a missing key with the base64 encoded ?default value.
+// Both the standard and the URL-safe alphabet are accepted, padding is optional.
+func serveDefault(w http.ResponseWriter, encoded string, maxSize int) {
+	encoded = strings.TrimRight(encoded, "=")
+	value, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		if value, err = base64.RawStdEncoding.DecodeString(encoded); err != nil {
+			writeError(w, "Invalid default, must be base64", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(value) > maxSize {
+		writeError(w, fmt.Sprintf("Default too large: limit is %d bytes", maxSize), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(value)
+}
+
+// isPeek reports whether the client asked to read without -touchOnGet extending the key's life,
+// with ?peek=1 or X-Peek: 1
+func isPeek(r *http.Request) bool {
+	return r.URL.Query().Get("peek") == "1" || r.Header.Get("X-Peek") == "1"
+}
+
+// touchEntry resets the expiration time of the key and returns the updated entry.
+// Entries are shared between concurrent readers, so a modified copy is stored
+// atomically instead of mutating the existing one.
+func touchEntry(key string, entry *Entry) *Entry {
+	now := time.Now().Unix()
+	updated, exists := kvMap.Update(key, func(upd *entryUpdate) {
+		if !upd.Exists {
+			upd.Cancel()
 			return
 		}
+		touched := *upd.Value
+		touched.LastUpdate = now
+		upd.Set(&touched)
+	})
+	if !exists {
+		// Deleted concurrently, serve what was read
+		return entry
+	}
+	return updated
+}
+
+// setEntryHeaders adds entry metadata headers so clients can tell how long the value will live.
+// The owner, identified by X-Owner-Secret, also gets the creator's address if it was recorded.
+func setEntryHeaders(w http.ResponseWriter, r *http.Request, key string, entry *Entry) {
+	remaining := time.Until(time.Unix(entry.LastUpdate, 0).Add(entry.expiration(key)))
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("X-Expires-In", strconv.FormatInt(int64(remaining/time.Second), 10))
+	w.Header().Set("X-Last-Update", strconv.FormatInt(entry.LastUpdate, 10))
+	w.Header().Set("Last-Modified", time.Unix(entry.LastUpdate, 0).UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", entry.etag())
+	w.Header().Set("X-Content-SHA256", entry.checksum())
+	setCustomHeaders(w, entry)
+	if *recordCreatorIP && entry.CreatorIP != "" && entry.Secret != "" {
+		if secret := r.Header.Get("X-Owner-Secret"); secret != "" && checkSecret(entry.Secret, secret) {
+			w.Header().Set("X-Creator-IP", entry.CreatorIP)
+		}
+	}
+}
+
+// notModified reports whether the client's cached copy is current, by If-None-Match or,
+// if that is absent, If-Modified-Since (second precision, like LastUpdate)
+func notModified(r *http.Request, entry *Entry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, entry)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if t, err := http.ParseTime(ims); err == nil {
+			return entry.LastUpdate <= t.Unix()
+		}
+	}
+	return false
+}
 
-		// Copy the stored value
-		value := entry.Value
+// autoCleanupInterval returns a tenth of the shortest expiration, between 1s and 1m
+func autoCleanupInterval(shortest time.Duration) time.Duration {
+	return min(max(shortest/10, time.Second), time.Minute)
+}
 
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Write(value)
+// configuredExpiration returns the shortest expiration of keys without a per-key TTL
+func configuredExpiration() time.Duration {
+	cfg := currentConfig()
+	shortest := cfg.expireDuration
+	for _, ns := range cfg.namespaces {
+		shortest = min(shortest, ns.expireDuration)
 	}
+	return shortest
 }
 
-// cleanupExpiredKeys periodically removes expired key-value pairs
-func cleanupExpiredKeys() {
+// cleanupExpiredKeys periodically removes expired key-value pairs until ctx is cancelled.
+// Without -cleanupInterval, each pass is scheduled relative to the shortest expiration
+// seen in the previous one, so short TTLs don't linger long past expiry.
+func cleanupExpiredKeys(ctx context.Context) {
+	interval := *cleanupInterval
+	if interval <= 0 {
+		interval = autoCleanupInterval(configuredExpiration())
+	}
 	for {
-		time.Sleep(1 * time.Minute)
-		now := time.Now()
-		expiredCount := 0
-		kvMap.Range(func(key string, entry *Entry) bool {
-			if now.Sub(time.Unix(entry.LastUpdate, 0)) > *expireDuration {
-				kvMap.Delete(key)
-				expiredCount++
-			}
-			return true
-		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		expiredCount, shortest := purgeExpiredKeys(ctx)
 		if expiredCount > 0 {
 			log.Printf("Cleaned up %d expired keys", expiredCount)
 		}
+		if *tombstoneTTL > 0 {
+			pruneTombstones()
+		}
+		if *cleanupInterval <= 0 {
+			interval = autoCleanupInterval(shortest)
+		}
 	}
 }
 
-// resetRateLimit resets the map storing requests counter per IP
-func resetRateLimit() {
-	for {
-		time.Sleep(*resetDuration)
-		mu.Lock()
-		rateLimit = make(map[[4]byte]uint8)
-		mu.Unlock()
+// purgeExpiredKeys removes all expired keys, returning how many were removed and the shortest
+// expiration among the remaining ones. Stops early if ctx is cancelled.
+func purgeExpiredKeys(ctx context.Context) (removed int, shortest time.Duration) {
+	now := time.Now()
+	shortest = configuredExpiration()
+	kvMap.Range(func(key string, entry *Entry) bool {
+		if entry.expired(key, now) {
+			deleteKey(key)
+			removed++
+		} else {
+			shortest = min(shortest, entry.expiration(key))
+		}
+		return ctx.Err() == nil
+	})
+	expiredKeysTotal.Add(int64(removed))
+	return removed, shortest
+}
+
+// loadIndexFile replaces the embedded page with -indexFile, keeping the embedded one if it can't be read
+func loadIndexFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("WARNING: Can't read indexFile, serving the built-in page: %v", err)
+		return
 	}
+	indexHtml = data
 }
 
 // gzip indexHtml
@@ -290,53 +1246,352 @@ func precompressIndexHtml() {
 
 func main() {
 	flag.Parse()
-	precompressIndexHtml()
+	explicitFlags := commandLineFlags()
+	if *configFile != "" {
+		if err := applyConfigFile(*configFile, explicitFlags); err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+	}
+	if *ipv6Prefix < 0 || *ipv6Prefix > 128 {
+		log.Fatal("ipv6Prefix must be between 0 and 128")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("Both -tlsCert and -tlsKey must be provided to enable TLS")
+	}
+	if *autocertDomain != "" && *tlsCert != "" {
+		log.Fatal("-autocertDomain cannot be combined with -tlsCert/-tlsKey")
+	}
+	if addr, isInterface, err := interfaceAddr(*listen); err != nil {
+		log.Fatalf("Can't listen on -l %s: %v", *listen, err)
+	} else if isInterface {
+		log.Printf("Listening on %s, the address of interface %s", addr, *listen)
+		*listen = addr
+	}
+	if *forwardedHop < 0 || *forwardedHop > maxForwardedHops {
+		log.Fatalf("forwardedHop must be between 0 and %d", maxForwardedHops)
+	}
+	if *autocertDomain != "" && strings.HasPrefix(*listen, "unix:") {
+		log.Fatal("-autocertDomain cannot be used with a unix socket")
+	}
+	if *httpPort != "" && (*tlsCert == "" || strings.HasPrefix(*listen, "unix:")) {
+		log.Fatal("-httpPort requires -tlsCert and a TCP address")
+	}
+	if *redirectHTTPS && *autocertDomain == "" && *httpPort == "" {
+		log.Fatal("-redirectHTTPS requires -autocertDomain or -httpPort")
+	}
+	if *backupDir != "" {
+		if *backupInterval <= 0 || *backupKeep < 1 {
+			log.Fatal("backupInterval and backupKeep must be positive")
+		}
+		if err := os.MkdirAll(*backupDir, 0755); err != nil {
+			log.Fatalf("Error creating backupDir: %v", err)
+		}
+	}
+	if *webhookURL != "" && !validateWebhookURL(*webhookURL) {
+		log.Fatal("webhookURL must be an absolute http or https URL")
+	}
+	if *cleanupInterval < 0 {
+		log.Fatal("cleanupInterval can't be negative")
+	}
+	if _, err := strconv.ParseUint(*socketMode, 8, 32); err != nil {
+		log.Fatal("socketMode must be an octal file mode such as 0660")
+	}
+	allowedContentTypeSet = parseContentTypeList(*allowedTypes)
+	if *blocklist != "" {
+		if err := loadBlocklist(*blocklist); err != nil {
+			log.Fatalf("Error loading blocklist: %v", err)
+		}
+	}
+	if *keyPattern != "" {
+		re, err := regexp.Compile(*keyPattern)
+		if err != nil {
+			log.Fatalf("Invalid keyPattern: %v", err)
+		}
+		keyRegexp = re
+	}
 
-	var err error
-	kvMap, err = persist.Map[*Entry](kvStore, "kv")
+	if *logFormat != "text" && *logFormat != "json" {
+		log.Fatal("logFormat must be text or json")
+	}
+	if *emptyPost != "store" && *emptyPost != "reject" && *emptyPost != "delete" {
+		log.Fatal("emptyPost must be store, reject or delete")
+	}
+	if *accessLog != "" {
+		if *accessLogSize < 0 || *accessLogKeep < 0 {
+			log.Fatal("accessLogMaxSize and accessLogKeep can't be negative")
+		}
+		logFile, err := openRotatingFile(*accessLog, *accessLogSize, *accessLogKeep)
+		if err != nil {
+			log.Fatalf("Error opening accessLog: %v", err)
+		}
+		defer logFile.Close()
+		accessLogOut = logFile
+	}
+
+	if *errorFormat != "text" && *errorFormat != "json" {
+		log.Fatal("errorFormat must be text or json")
+	}
+
+	if *evictionPolicy != "reject" && *evictionPolicy != "lru" {
+		log.Fatal("evictionPolicy must be reject or lru")
+	}
+
+	cfg, err := buildConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
+	config.Store(cfg)
+	if *adminToken == "" {
+		adminRoutes = nil
+	}
+	if *indexFile != "" && !*disableIndex {
+		loadIndexFile(*indexFile)
+	}
+	if *robotsFile != "" {
+		data, err := os.ReadFile(*robotsFile)
+		if err != nil {
+			log.Fatalf("Can't read robotsFile: %v", err)
+		}
+		robotsTxt = data
+	}
+	precompressIndexHtml()
 
-	err = kvStore.Open("store.db")
+	switch *backend {
+	case "persist":
+		kvMap, err = newPersistBackend(*saveDuration)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "memory":
+		kvMap = newMemoryBackend()
+		ephemeral = true
+		log.Printf("WARNING: Using the memory backend, all data will be lost on restart!")
+	default:
+		log.Fatal("backend must be persist or memory")
+	}
+
+	err = kvMap.Open(*storePath)
 	if err != nil {
-		log.Fatal(err)
+		if !*allowEphemeral {
+			log.Fatal(err)
+		}
+		log.Printf("WARNING: Can't open store %s: %v", *storePath, err)
+		log.Printf("WARNING: Running in ephemeral mode, all data will be lost on restart!")
+		ephemeral = true
+		kvMap = newMemoryBackend()
+	}
+	defer kvMap.Close()
+	if *bloomFilter {
+		kvMap = newBloomBackend(kvMap, *maxNumKV)
+	}
+	countStoreUsage()
+	if *keepRateLimit {
+		loadRateLimits()
+	}
+	storeReady.Store(true)
+
+	// Background work is stopped and waited for before the store is closed
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	runBackground := func(task func(ctx context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			task(backgroundCtx)
+		}()
+	}
+	defer func() {
+		stopBackground()
+		background.Wait()
+	}()
+	runBackground(cleanupExpiredKeys)
+	runBackground(pruneRateLimit)
+	if *backupDir != "" && !ephemeral {
+		runBackground(backupPeriodically)
+	}
+	if *webhookURL != "" {
+		startWebhooks()
+	}
+	addr := net.JoinHostPort(*listen, *port)
+	if strings.HasPrefix(*listen, "unix:") {
+		addr = *listen
+	}
+	server := newServer(addr, rootHandler())
+	// All running servers, shut down together
+	servers := []*http.Server{server}
+	if *metricsAddr != "" {
+		servers = append(servers, serveMetrics(*metricsAddr))
 	}
-	defer kvStore.Close()
 
-	kvStore.SetSyncInterval(*saveDuration)
-	go cleanupExpiredKeys()
-	go resetRateLimit()
+	if *autocertDomain != "" {
+		certManager := newCertManager()
+		// The plain listener answers ACME HTTP-01 challenges and keeps serving the API
+		// unless it redirects to HTTPS
+		plainHandler := server.Handler
+		if *redirectHTTPS {
+			plainHandler = redirectToHTTPS("443")
+		}
+		server.Handler = certManager.HTTPHandler(plainHandler)
 
-	addr := *listen + ":" + *port
-	server := &http.Server{
-		Addr:                         addr,
-		Handler:                      http.HandlerFunc(mainHandler),
-		ReadTimeout:                  10 * time.Second,
-		WriteTimeout:                 10 * time.Second,
-		MaxHeaderBytes:               1 << 13, // 8 kb
-		DisableGeneralOptionsHandler: true,
+		tlsAddr := net.JoinHostPort(*listen, "443")
+		tlsServer := newServer(tlsAddr, rootHandler())
+		tlsServer.TLSConfig = certManager.TLSConfig()
+		servers = append(servers, tlsServer)
+		go func() {
+			log.Println("Server is starting on https://" + tlsAddr)
+			if err := listenAndServe(tlsServer, true, "", ""); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
 	}
 
-	server.SetKeepAlivesEnabled(false)
+	// Plain HTTP next to -tlsCert, serving the API or redirecting to it
+	if *httpPort != "" {
+		httpAddr := net.JoinHostPort(*listen, *httpPort)
+		httpHandler := rootHandler()
+		if *redirectHTTPS {
+			httpHandler = redirectToHTTPS(*port)
+		}
+		httpServer := newServer(httpAddr, httpHandler)
+		servers = append(servers, httpServer)
+		go func() {
+			log.Println("Server is starting on http://" + httpAddr)
+			if err := listenAndServe(httpServer, false, "", ""); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
 
 	// Graceful shutdown
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	// Closed once in-flight requests are done, the store must stay open until then
+	shutdownDone := make(chan struct{})
 	go func() {
 		sig := <-sigs
 		log.Printf("Received signal %v, shutting down...", sig)
+		storeReady.Store(false)
+		defer close(shutdownDone)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
 		defer cancel()
 
-		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("HTTP server shutdown error: %v", err)
+		for _, srv := range servers {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("HTTP server shutdown error: %v, closing remaining connections", err)
+				srv.Close()
+			}
 		}
 	}()
 
-	log.Println("Server is starting on http://" + addr)
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+	// Reload the blocklist and the reloadable part of the config file on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if *blocklist != "" {
+				if err := loadBlocklist(*blocklist); err != nil {
+					log.Printf("Blocklist reload failed: %v", err)
+				} else {
+					log.Printf("Blocklist reloaded from %s", *blocklist)
+				}
+			}
+			if *configFile == "" {
+				continue
+			}
+			if err := reloadConfig(*configFile, explicitFlags); err != nil {
+				log.Printf("Config reload failed: %v", err)
+				continue
+			}
+			log.Printf("Config reloaded from %s", *configFile)
+		}
+	}()
+
+	if *tlsCert != "" {
+		log.Println("Server is starting on https://" + addr)
+		err = listenAndServe(server, true, *tlsCert, *tlsKey)
+	} else {
+		log.Println("Server is starting on http://" + addr)
+		err = listenAndServe(server, false, "", "")
+	}
+	if err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-shutdownDone
+	if *keepRateLimit {
+		saveRateLimits()
+	}
+}
+
+// rootHandler returns the main handler wrapped with the enabled middlewares
+func rootHandler() http.Handler {
+	var handler http.Handler = http.HandlerFunc(mainHandler)
+	if *logFormat == "json" || *accessLog != "" {
+		handler = accessLogHandler(handler)
+	}
+	return handler
+}
+
+// unixSocketContextKey marks the context of connections accepted on a unix socket
+type unixSocketContextKey struct{}
+
+// clientKeyContextKey holds the client's rate limit key in the request context
+type clientKeyContextKey struct{}
+
+// requestedKeyContextKey holds the key of a POST before it was prefixed with the client's IP
+type requestedKeyContextKey struct{}
+
+// listenAndServe starts serving on server.Addr, which is host:port or unix:/path,
+// reading PROXY protocol headers if enabled
+func listenAndServe(server *http.Server, useTLS bool, certFile, keyFile string) error {
+	network, address := "tcp", server.Addr
+	if path, ok := strings.CutPrefix(server.Addr, "unix:"); ok {
+		network, address = "unix", path
+		// Remove a stale socket left by an unclean shutdown, graceful shutdown removes it itself
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, unixSocketContextKey{}, true)
+		}
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		mode, _ := strconv.ParseUint(*socketMode, 8, 32)
+		if err := os.Chmod(address, os.FileMode(mode)); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+	ln = &countingListener{ln}
+	if *proxyProtocol {
+		ln = &proxyListener{ln}
+	}
+	if useTLS {
+		return server.ServeTLS(ln, certFile, keyFile)
+	}
+	return server.Serve(ln)
+}
+
+// newServer creates an HTTP server with the common limits and timeouts
+func newServer(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:                         addr,
+		Handler:                      handler,
+		ReadTimeout:                  *readTimeout,
+		WriteTimeout:                 *writeTimeout,
+		MaxHeaderBytes:               1 << 13, // 8 kb
+		IdleTimeout:                  *idleTimeout,
+		DisableGeneralOptionsHandler: true,
+	}
+	// Without keep-alive every request uses a new connection
+	server.SetKeepAlivesEnabled(*keepAlive)
+	// Shutdown waits for active requests, so release long polls and event streams right away
+	server.RegisterOnShutdown(startDraining)
+	return server
 }
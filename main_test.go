@@ -0,0 +1,435 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStore swaps in an empty memory store and forgets all usage counters
+// and rate limits, so every test starts from a fresh server
+func newTestStore(t *testing.T) {
+	t.Helper()
+	kvMap = newMemoryBackend()
+	storeBytes.Store(0)
+	creatorKeysMu.Lock()
+	creatorKeys = make(map[[16]byte]int)
+	creatorKeysMu.Unlock()
+	prefixKeysMu.Lock()
+	prefixKeys = make(map[string]int)
+	prefixKeysMu.Unlock()
+	mu.Lock()
+	rateLimit = make(map[[16]byte]*bucket)
+	mu.Unlock()
+	keyRateLimitMu.Lock()
+	keyRateLimit = make(map[string]*bucket)
+	keyRateLimitMu.Unlock()
+	// Most tests send more requests than the default rate limit allows
+	setFlag(t, "maxRequests", "1000")
+}
+
+// setFlag changes a flag for the duration of the test and applies it to the runtime configuration
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flag.Set(name, old)
+		if cfg, err := buildConfig(); err == nil {
+			config.Store(cfg)
+		}
+	})
+	cfg, err := buildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Store(cfg)
+}
+
+// post builds a POST of value to key
+func post(key, value string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/"+key, strings.NewReader(value))
+}
+
+// serve passes the request through mainHandler and returns the recorded response
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	mainHandler(w, r)
+	return w
+}
+
+func TestEtagMatches(t *testing.T) {
+	entry := &Entry{Value: []byte("v"), LastUpdate: 1}
+	tag := entry.etag()
+	for header, want := range map[string]bool{
+		tag:                    true,
+		"W/" + tag:             true,
+		`"other", ` + tag:      true,
+		"*":                    true,
+		`"other"`:              false,
+		strings.Trim(tag, `"`): false,
+	} {
+		if got := etagMatches(header, entry); got != want {
+			t.Errorf("etagMatches(%q) = %v, want %v", header, got, want)
+		}
+	}
+	// Touching a key on read keeps its version, only a new value changes it
+	if (&Entry{Value: []byte("v"), LastUpdate: 2}).etag() != tag {
+		t.Error("ETag changes with LastUpdate")
+	}
+	if (&Entry{Value: []byte("w"), LastUpdate: 1}).etag() == tag {
+		t.Error("ETag doesn't change with the value")
+	}
+}
+
+func TestPostIfMatch(t *testing.T) {
+	newTestStore(t)
+
+	// Nothing to compare against, so the key isn't created
+	r := post("k", "v1")
+	r.Header.Set("If-Match", "*")
+	if w := serve(r); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match on a missing key: got %d, want 412", w.Code)
+	}
+	if _, exists := kvMap.Get("k"); exists {
+		t.Fatal("failed If-Match created the key")
+	}
+
+	w := serve(post("k", "v1"))
+	first := w.Header().Get("ETag")
+	if entry, _ := kvMap.Get("k"); first == "" || first != entry.etag() {
+		t.Fatalf("POST returned ETag %q, stored entry has %q", first, entry.etag())
+	}
+
+	r = post("k", "v2")
+	r.Header.Set("If-Match", first)
+	w = serve(r)
+	second := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || second == first {
+		t.Fatalf("If-Match with the current ETag: got %d, ETag %q", w.Code, second)
+	}
+
+	// The loser of a race learns the winner's version to retry against
+	r = post("k", "v3")
+	r.Header.Set("If-Match", first)
+	w = serve(r)
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != second {
+		t.Fatalf("stale If-Match: got %d with ETag %q, want 412 with %q", w.Code, w.Header().Get("ETag"), second)
+	}
+	if entry, _ := kvMap.Get("k"); string(entry.Value) != "v2" {
+		t.Fatalf("stale If-Match overwrote the value with %q", entry.Value)
+	}
+}
+
+func TestPostIfMatchOwnedKey(t *testing.T) {
+	newTestStore(t)
+	r := post("k", "v1")
+	r.Header.Set("X-Owner-Secret", "s")
+	etag := serve(r).Header().Get("ETag")
+
+	// Ownership is checked first, so a wrong secret can't probe ETags
+	r = post("k", "v2")
+	r.Header.Set("If-Match", `"guess"`)
+	if w := serve(r); w.Code != http.StatusForbidden || w.Header().Get("ETag") != "" {
+		t.Fatalf("If-Match without the owner secret: got %d with ETag %q, want 403 without", w.Code, w.Header().Get("ETag"))
+	}
+	r = post("k", "v2")
+	r.Header.Set("X-Owner-Secret", "s")
+	r.Header.Set("If-Match", etag)
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("If-Match with the owner secret: got %d", w.Code)
+	}
+}
+
+func TestPostIfMatchPrivateKey(t *testing.T) {
+	newTestStore(t)
+	r := post("k", "v1")
+	r.Header.Set("X-Read-Secret", "r")
+	etag := serve(r).Header().Get("ETag")
+
+	// A matching ETag would confirm a guess of the private value
+	r = post("k", "v1")
+	r.Header.Set("If-Match", etag)
+	if w := serve(r); w.Code != http.StatusForbidden {
+		t.Fatalf("If-Match without the read secret: got %d, want 403", w.Code)
+	}
+	r = post("k", "v2")
+	r.Header.Set("If-Match", `"stale"`)
+	if w := serve(r); w.Code != http.StatusForbidden || w.Header().Get("ETag") != "" {
+		t.Fatalf("stale If-Match without the read secret: got %d with ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	// Readers get the current version back as usual
+	r = post("k", "v2")
+	r.Header.Set("If-Match", `"stale"`)
+	r.Header.Set("X-Read-Secret", "r")
+	if w := serve(r); w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != etag {
+		t.Fatalf("stale If-Match with the read secret: got %d with ETag %q, want 412 with %q", w.Code, w.Header().Get("ETag"), etag)
+	}
+	r = post("k", "v2")
+	r.Header.Set("If-Match", etag)
+	r.Header.Set("X-Read-Secret", "r")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("If-Match with the read secret: got %d", w.Code)
+	}
+}
+
+func TestPostIfNoneMatch(t *testing.T) {
+	newTestStore(t)
+	r := post("k", "v1")
+	r.Header.Set("If-None-Match", "*")
+	w := serve(r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create-only POST of a new key: got %d", w.Code)
+	}
+	etag := w.Header().Get("ETag")
+
+	// Only the first of several racing creators wins
+	r = post("k", "v2")
+	r.Header.Set("If-None-Match", "*")
+	if w := serve(r); w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != etag {
+		t.Fatalf("create-only POST of an existing key: got %d with ETag %q, want 412 with %q", w.Code, w.Header().Get("ETag"), etag)
+	}
+	if entry, _ := kvMap.Get("k"); string(entry.Value) != "v1" {
+		t.Fatalf("failed create-only POST stored %q", entry.Value)
+	}
+
+	// A specific ETag fails only while it's current
+	r = post("k", "v2")
+	r.Header.Set("If-None-Match", etag)
+	if w := serve(r); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-None-Match with the current ETag: got %d, want 412", w.Code)
+	}
+	r = post("k", "v2")
+	r.Header.Set("If-None-Match", `"other"`)
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("If-None-Match with another ETag: got %d", w.Code)
+	}
+}
+
+func TestPostIfNoneMatchExpiredKey(t *testing.T) {
+	newTestStore(t)
+	// Left over until the next cleanup, but already gone for clients
+	kvMap.Set("k", &Entry{Value: []byte("old"), LastUpdate: time.Now().Add(-3 * time.Hour).Unix()})
+	r := post("k", "new")
+	r.Header.Set("If-None-Match", "*")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("create-only POST over an expired key: got %d", w.Code)
+	}
+	if entry, _ := kvMap.Get("k"); string(entry.Value) != "new" {
+		t.Fatalf("expired value %q not replaced", entry.Value)
+	}
+}
+
+func TestPostIfNoneMatchChecksSecrets(t *testing.T) {
+	newTestStore(t)
+	r := post("owned", "v")
+	r.Header.Set("X-Owner-Secret", "s")
+	serve(r)
+	r = post("private", "v")
+	r.Header.Set("X-Read-Secret", "r")
+	serve(r)
+
+	// Without the secrets a 412 would confirm that the key exists or holds a guessed value
+	r = post("owned", "v")
+	r.Header.Set("If-None-Match", "*")
+	if w := serve(r); w.Code != http.StatusForbidden {
+		t.Fatalf("create-only POST to an owned key without its secret: got %d, want 403", w.Code)
+	}
+	r = post("private", "v")
+	r.Header.Set("If-None-Match", "*")
+	if w := serve(r); w.Code != http.StatusForbidden || w.Header().Get("ETag") != "" {
+		t.Fatalf("create-only POST to a private key without its read secret: got %d with ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+// deleteFrom builds a DELETE of key sent from the given client address
+func deleteFrom(client, key string) *http.Request {
+	r := httptest.NewRequest(http.MethodDelete, "/"+key, nil)
+	r.RemoteAddr = client
+	return r
+}
+
+func TestDeleteUnownedKey(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "recordCreatorIP", "true")
+	serve(post("k", "v"))
+
+	if w := serve(deleteFrom("192.0.2.2:1234", "k")); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE from another address: got %d, want 403", w.Code)
+	}
+	if w := serve(deleteFrom("192.0.2.1:1234", "k")); w.Code != http.StatusOK {
+		t.Fatalf("DELETE from the creator: got %d", w.Code)
+	}
+	if _, exists := kvMap.Get("k"); exists {
+		t.Fatal("key still stored after DELETE")
+	}
+
+	// Owned keys still need their secret, whoever created them
+	r := post("owned", "v")
+	r.Header.Set("X-Owner-Secret", "s")
+	serve(r)
+	if w := serve(deleteFrom("192.0.2.1:1234", "owned")); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE of an owned key without its secret: got %d, want 403", w.Code)
+	}
+}
+
+func TestDeleteUnownedKeyWithoutCreator(t *testing.T) {
+	newTestStore(t)
+	// Without a recorded creator nobody can prove they created the key
+	serve(post("k", "v"))
+	if w := serve(deleteFrom("192.0.2.1:1234", "k")); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE without a recorded creator: got %d, want 403", w.Code)
+	}
+}
+
+func TestIncrementPrivateKey(t *testing.T) {
+	newTestStore(t)
+	r := post("k", "41")
+	r.Header.Set("X-Read-Secret", "r")
+	serve(r)
+
+	// The new value would reveal the counter to anyone
+	r = post("k", "")
+	r.Header.Set("X-Op", "increment")
+	if w := serve(r); w.Code != http.StatusForbidden || w.Header().Get("ETag") != "" || strings.Contains(w.Body.String(), "42") {
+		t.Fatalf("increment without the read secret: got %d %q with ETag %q", w.Code, w.Body, w.Header().Get("ETag"))
+	}
+	r = post("k", "")
+	r.Header.Set("X-Op", "increment")
+	r.Header.Set("X-Read-Secret", "r")
+	if w := serve(r); w.Code != http.StatusOK || w.Body.String() != "42" {
+		t.Fatalf("increment with the read secret: got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestPrivateKeyNonReaders(t *testing.T) {
+	newTestStore(t)
+	r := post("k", "secret value")
+	r.Header.Set("X-Read-Secret", "r")
+	serve(r)
+
+	// Taking over the read secret would let the caller read the value after an empty append
+	r = post("k", "x")
+	r.Header.Set("X-Read-Secret", "mine")
+	if w := serve(r); w.Code != http.StatusForbidden {
+		t.Fatalf("new read secret without the current one: got %d, want 403", w.Code)
+	}
+	r = post("k", "")
+	r.Header.Set("X-Op", "append")
+	if w := serve(r); w.Code != http.StatusForbidden {
+		t.Fatalf("append without the read secret: got %d, want 403", w.Code)
+	}
+	if entry, _ := kvMap.Get("k"); string(entry.Value) != "secret value" || !checkSecret(entry.ReadSecret, "r") {
+		t.Fatalf("private key changed by a non-reader: %+v", entry)
+	}
+
+	// Readers may keep updating it, and once owned the owner may replace the read secret
+	r = post("k", "v")
+	r.Header.Set("X-Read-Secret", "r")
+	r.Header.Set("X-Owner-Secret", "s")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("update from a reader: got %d", w.Code)
+	}
+	r = post("k", "v")
+	r.Header.Set("X-Read-Secret", "r2")
+	r.Header.Set("X-Owner-Secret", "s")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("new read secret from the owner: got %d", w.Code)
+	}
+	if entry, _ := kvMap.Get("k"); !checkSecret(entry.ReadSecret, "r2") {
+		t.Fatal("read secret not replaced by the owner")
+	}
+}
+
+func TestEmptyPostDelete(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "emptyPost", "delete")
+	setFlag(t, "recordCreatorIP", "true")
+	serve(post("k", "v"))
+
+	// Like DELETE, only the creator may remove an unowned key
+	r := post("k", "")
+	r.RemoteAddr = "192.0.2.2:1234"
+	if w := serve(r); w.Code != http.StatusForbidden {
+		t.Fatalf("empty POST from another address: got %d, want 403", w.Code)
+	}
+	if w := serve(post("k", "")); w.Code != http.StatusOK {
+		t.Fatalf("empty POST from the creator: got %d", w.Code)
+	}
+	if _, exists := kvMap.Get("k"); exists {
+		t.Fatal("key still stored after an empty POST")
+	}
+
+	// Expired keys are gone already
+	kvMap.Set("old", &Entry{Value: []byte("v"), LastUpdate: time.Now().Add(-3 * time.Hour).Unix(), CreatorIP: "192.0.2.1"})
+	if w := serve(post("old", "")); w.Code != http.StatusNotFound {
+		t.Fatalf("empty POST to an expired key: got %d, want 404", w.Code)
+	}
+}
+
+func TestRotateOwnerSecretWithoutValue(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "emptyPost", "delete")
+	r := post("k", "v")
+	r.Header.Set("X-Owner-Secret", "old")
+	r.Header.Set("X-TTL", "1m")
+	r.Header.Set("X-Set-Header", "Cache-Control: no-store")
+	serve(r)
+	before, _ := kvMap.Get("k")
+
+	r = post("k", "")
+	r.Header.Set("X-Owner-Secret", "old")
+	r.Header.Set("X-New-Owner-Secret", "new")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("rotation without a value: got %d", w.Code)
+	}
+	after, exists := kvMap.Get("k")
+	if !exists || string(after.Value) != "v" || after.TTL != before.TTL || after.Headers != before.Headers || after.LastUpdate != before.LastUpdate {
+		t.Fatalf("rotation changed more than the owner: %+v", after)
+	}
+	if !checkSecret(after.Secret, "new") {
+		t.Fatal("secret not rotated")
+	}
+
+	// Transfers work the same way
+	r = post("k", "")
+	r.Header.Set("X-Owner-Secret", "new")
+	r.Header.Set("X-Transfer-To", "other")
+	serve(r)
+	if after, _ := kvMap.Get("k"); string(after.Value) != "v" || !checkSecret(after.Secret, "other") {
+		t.Fatalf("transfer without a value: %+v", after)
+	}
+}
+
+func TestHead(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "gzipResponses", "true")
+	setFlag(t, "compressMinSize", "1")
+	value := strings.Repeat("v", 100)
+	etag := serve(post("k", value)).Header().Get("ETag")
+
+	head := func(headers ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodHead, "/k", nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		return serve(r)
+	}
+	// The value isn't compressed just to measure it
+	w := head("Accept-Encoding", "gzip")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" || w.Header().Get("Content-Length") != "100" || w.Header().Get("ETag") != etag {
+		t.Fatalf("HEAD: got %d with headers %v", w.Code, w.Header())
+	}
+	if w := head("If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("HEAD with the current ETag in If-None-Match: got %d, want 304", w.Code)
+	}
+	if w := head("If-Match", `"stale"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("HEAD with a stale If-Match: got %d, want 412", w.Code)
+	}
+}
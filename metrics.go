@@ -0,0 +1,149 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Methods tracked individually in rendezvous_requests_total, everything else is counted as OTHER
+var metricsMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodDelete,
+	"OTHER",
+}
+
+// Counters exposed via the /metrics endpoint
+var (
+	requestsTotal         = make(map[string]*atomic.Int64, len(metricsMethods))
+	rateLimitedTotal      atomic.Int64 // requests rejected with 429
+	expiredKeysTotal      atomic.Int64 // keys removed by cleanupExpiredKeys
+	capacityRejectedTotal atomic.Int64 // writes rejected because the store is full
+	evictedKeysTotal      atomic.Int64 // keys evicted to make room for new writes
+	connRejectedTotal     atomic.Int64 // connections closed because -maxConns was reached
+	webhookFailedTotal    atomic.Int64 // webhook notifications dropped or not delivered
+	bloomSkippedTotal     atomic.Int64 // lookups of missing keys answered by -bloomFilter
+)
+
+func init() {
+	for _, method := range metricsMethods {
+		requestsTotal[method] = new(atomic.Int64)
+	}
+
+	// The same numbers for /debug/vars, see expvarHandler
+	expvar.Publish("keys", expvar.Func(func() any { return kvMap.Size() }))
+	expvar.Publish("valueBytes", expvar.Func(func() any { return storeBytes.Load() }))
+	expvar.Publish("rateLimited", expvar.Func(func() any { return rateLimitedTotal.Load() }))
+	expvar.Publish("requests", expvar.Func(func() any {
+		counts := make(map[string]int64, len(requestsTotal))
+		for method, counter := range requestsTotal {
+			counts[method] = counter.Load()
+		}
+		return counts
+	}))
+}
+
+// countRequest increments the request counter for the given HTTP method
+func countRequest(method string) {
+	counter, ok := requestsTotal[method]
+	if !ok {
+		counter = requestsTotal["OTHER"]
+	}
+	counter.Add(1)
+}
+
+// metricsHandler writes all counters in the Prometheus text exposition format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP rendezvous_requests_total Total number of HTTP requests by method.")
+	fmt.Fprintln(w, "# TYPE rendezvous_requests_total counter")
+	for _, method := range metricsMethods {
+		fmt.Fprintf(w, "rendezvous_requests_total{method=%q} %d\n", method, requestsTotal[method].Load())
+	}
+
+	fmt.Fprintln(w, "# HELP rendezvous_rate_limited_total Total number of requests rejected by the rate limiter.")
+	fmt.Fprintln(w, "# TYPE rendezvous_rate_limited_total counter")
+	fmt.Fprintf(w, "rendezvous_rate_limited_total %d\n", rateLimitedTotal.Load())
+
+	fmt.Fprintln(w, "# HELP rendezvous_keys Current number of stored keys.")
+	fmt.Fprintln(w, "# TYPE rendezvous_keys gauge")
+	fmt.Fprintf(w, "rendezvous_keys %d\n", kvMap.Size())
+
+	fmt.Fprintln(w, "# HELP rendezvous_value_bytes Current total size of stored values in bytes.")
+	fmt.Fprintln(w, "# TYPE rendezvous_value_bytes gauge")
+	fmt.Fprintf(w, "rendezvous_value_bytes %d\n", storeBytes.Load())
+
+	fmt.Fprintln(w, "# HELP rendezvous_connections Current number of open client connections.")
+	fmt.Fprintln(w, "# TYPE rendezvous_connections gauge")
+	fmt.Fprintf(w, "rendezvous_connections %d\n", activeConns.Load())
+
+	fmt.Fprintln(w, "# HELP rendezvous_expired_keys_total Total number of keys removed after expiration.")
+	fmt.Fprintln(w, "# TYPE rendezvous_expired_keys_total counter")
+	fmt.Fprintf(w, "rendezvous_expired_keys_total %d\n", expiredKeysTotal.Load())
+
+	fmt.Fprintln(w, "# HELP rendezvous_capacity_rejections_total Total number of writes rejected because the store was full.")
+	fmt.Fprintln(w, "# TYPE rendezvous_capacity_rejections_total counter")
+	fmt.Fprintf(w, "rendezvous_capacity_rejections_total %d\n", capacityRejectedTotal.Load())
+
+	fmt.Fprintln(w, "# HELP rendezvous_evicted_keys_total Total number of keys evicted to make room for new writes.")
+	fmt.Fprintln(w, "# TYPE rendezvous_evicted_keys_total counter")
+	fmt.Fprintf(w, "rendezvous_evicted_keys_total %d\n", evictedKeysTotal.Load())
+
+	fmt.Fprintln(w, "# HELP rendezvous_rejected_connections_total Total number of connections closed because maxConns was reached.")
+	fmt.Fprintln(w, "# TYPE rendezvous_rejected_connections_total counter")
+	fmt.Fprintf(w, "rendezvous_rejected_connections_total %d\n", connRejectedTotal.Load())
+
+	fmt.Fprintln(w, "# HELP rendezvous_webhook_failures_total Total number of webhook notifications dropped or not delivered.")
+	fmt.Fprintln(w, "# TYPE rendezvous_webhook_failures_total counter")
+	fmt.Fprintf(w, "rendezvous_webhook_failures_total %d\n", webhookFailedTotal.Load())
+
+	fmt.Fprintln(w, "# HELP rendezvous_bloom_skipped_lookups_total Total number of lookups of missing keys answered by the bloom filter.")
+	fmt.Fprintln(w, "# TYPE rendezvous_bloom_skipped_lookups_total counter")
+	fmt.Fprintf(w, "rendezvous_bloom_skipped_lookups_total %d\n", bloomSkippedTotal.Load())
+}
+
+// expvarVars are the expvar variables served at /debug/vars. The cmdline variable expvar
+// publishes itself is left out, it would reveal -adminToken and namespace secrets.
+var expvarVars = []string{"keys", "valueBytes", "rateLimited", "requests", "memstats"}
+
+// expvarHandler writes expvarVars in the JSON format of expvar.Handler
+func expvarHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{\n")
+	for i, name := range expvarVars {
+		if i > 0 {
+			fmt.Fprint(w, ",\n")
+		}
+		fmt.Fprintf(w, "%q: %s", name, expvar.Get(name))
+	}
+	fmt.Fprint(w, "\n}\n")
+}
+
+// serveMetrics starts a separate listener that only serves /metrics,
+// the returned server is shut down with the main ones
+func serveMetrics(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	if *exposeExpvar {
+		mux.HandleFunc("/debug/vars", expvarHandler)
+	}
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	log.Println("Metrics are available on http://" + addr + "/metrics")
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Metrics listener error: %v", err)
+		}
+	}()
+	return server
+}
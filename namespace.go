@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namespace holds the settings of keys sharing a prefix
+type namespace struct {
+	prefix         string
+	maxValueSize   int
+	expireDuration time.Duration
+	ipPrefix       bool   // POST keys are prefixed with the client's IP address
+	secret         string // shared owner secret of all keys, plaintext or in the hashSecret form
+	maxNumKV       int    // maximum number of keys, 0 means only the global -maxNumKV applies
+}
+
+// parseNamespaces parses the -namespaces flag: "prefix:option=value,...;prefix:..."
+// Options not given fall back to the global flags. The result is sorted by prefix length,
+// longest first, so the most specific namespace matches.
+func parseNamespaces(s string) ([]namespace, error) {
+	var result []namespace
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		prefix, options, _ := strings.Cut(spec, ":")
+		if prefix == "" || !strings.HasSuffix(prefix, "/") {
+			return nil, fmt.Errorf("namespace prefix %q must end with /", prefix)
+		}
+		ns := namespace{
+			prefix:         prefix,
+			maxValueSize:   *maxValueSize,
+			expireDuration: *expireDuration,
+		}
+		for _, option := range strings.Split(options, ",") {
+			option = strings.TrimSpace(option)
+			if option == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(option, "=")
+			var err error
+			switch name {
+			case "maxValueSize":
+				ns.maxValueSize, err = strconv.Atoi(value)
+				if err == nil && ns.maxValueSize <= 0 {
+					err = fmt.Errorf("must be positive")
+				}
+			case "expireDuration":
+				ns.expireDuration, err = time.ParseDuration(value)
+				if err == nil && ns.expireDuration <= 0 {
+					err = fmt.Errorf("must be positive")
+				}
+			case "ipPrefix":
+				ns.ipPrefix, err = strconv.ParseBool(value)
+			case "maxNumKV":
+				ns.maxNumKV, err = strconv.Atoi(value)
+				if err == nil && ns.maxNumKV <= 0 {
+					err = fmt.Errorf("must be positive")
+				}
+				// Keys are counted per top-level prefix, see prefixKeys
+				if err == nil && strings.Count(prefix, "/") > 1 {
+					err = fmt.Errorf("only supported for top-level prefixes")
+				}
+			case "secret":
+				ns.secret = value
+				if value == "" || (isHashedSecret(value) && !validSecretHash(value)) {
+					err = fmt.Errorf("must be a secret or a valid hash")
+				}
+			default:
+				err = fmt.Errorf("unknown option")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("namespace %s: invalid %s: %v", prefix, option, err)
+			}
+		}
+		result = append(result, ns)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return len(result[i].prefix) > len(result[j].prefix)
+	})
+	return result, nil
+}
+
+// namespaceFor returns the settings applying to the key, the global flags if no namespace matches
+func namespaceFor(key string) namespace {
+	cfg := currentConfig()
+	for _, ns := range cfg.namespaces {
+		if strings.HasPrefix(key, ns.prefix) {
+			return ns
+		}
+	}
+	return namespace{
+		maxValueSize:   *maxValueSize,
+		expireDuration: cfg.expireDuration,
+	}
+}
+
+// largestValueSize returns the maximum value size allowed in any namespace or by chunked uploads
+func largestValueSize() int {
+	size := max(*maxValueSize, *assembledSize)
+	for _, ns := range currentConfig().namespaces {
+		size = max(size, ns.maxValueSize)
+	}
+	return size
+}
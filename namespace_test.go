@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseNamespaces(t *testing.T) {
+	parsed, err := parseNamespaces("a/:maxValueSize=10; a/b/:expireDuration=1m,ipPrefix=true;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Longest prefix first, so the most specific namespace matches
+	if len(parsed) != 2 || parsed[0].prefix != "a/b/" || parsed[1].prefix != "a/" {
+		t.Fatalf("parsed %+v", parsed)
+	}
+	// Options not given fall back to the global flags
+	if ns := parsed[0]; ns.maxValueSize != *maxValueSize || ns.expireDuration != time.Minute || !ns.ipPrefix {
+		t.Errorf("a/b/ parsed as %+v", ns)
+	}
+	if ns := parsed[1]; ns.maxValueSize != 10 || ns.expireDuration != *expireDuration || ns.ipPrefix {
+		t.Errorf("a/ parsed as %+v", ns)
+	}
+
+	for _, spec := range []string{
+		"a:maxValueSize=10",
+		"a/:maxValueSize=0",
+		"a/:expireDuration=-1m",
+		"a/:ipPrefix=maybe",
+		"a/:unknown=1",
+		"a/:secret=",
+		"a/:maxNumKV=0",
+		"a/b/:maxNumKV=1",
+	} {
+		if _, err := parseNamespaces(spec); err == nil {
+			t.Errorf("parseNamespaces(%q) accepted", spec)
+		}
+	}
+}
+
+func TestNamespaceFor(t *testing.T) {
+	setFlag(t, "namespaces", "a/:maxValueSize=10;a/b/:maxValueSize=20")
+	for key, want := range map[string]string{"a/b/c": "a/b/", "a/c": "a/", "ab/c": "", "a": ""} {
+		if got := namespaceFor(key).prefix; got != want {
+			t.Errorf("namespaceFor(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if ns := namespaceFor("other"); ns.maxValueSize != *maxValueSize || ns.expireDuration != *expireDuration {
+		t.Errorf("keys outside namespaces get %+v", ns)
+	}
+	if got := largestValueSize(); got != max(20, *maxValueSize) {
+		t.Errorf("largestValueSize() = %d", got)
+	}
+}
+
+func TestNamespaceLimits(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "namespaces", "small/:maxValueSize=4,expireDuration=1m;ip/:ipPrefix=true")
+
+	if w := serve(post("small/k", "12345")); w.Code != http.StatusBadRequest {
+		t.Fatalf("value over the namespace limit: got %d, want 400", w.Code)
+	}
+	if w := serve(post("other", "12345")); w.Code != http.StatusOK {
+		t.Fatalf("value outside the namespace: got %d", w.Code)
+	}
+	serve(post("small/k", "1234"))
+	w := serve(httptest.NewRequest(http.MethodGet, "/small/k", nil))
+	if expiresIn, _ := strconv.Atoi(w.Header().Get("X-Expires-In")); expiresIn <= 0 || expiresIn > 60 {
+		t.Fatalf("X-Expires-In %q, want the namespace expireDuration", w.Header().Get("X-Expires-In"))
+	}
+
+	// POSTs to IP namespaces land under the client's address
+	if w := serve(post("ip/peer", "v")); w.Body.String() != "192.0.2.1" {
+		t.Fatalf("POST to ip/ answered %q", w.Body)
+	}
+	if _, exists := kvMap.Get("ip/192.0.2.1/peer"); !exists {
+		t.Fatal("ip/ key not stored under the client's address")
+	}
+}
+
+func TestNamespaceSecret(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "namespaces", "team/:secret=s")
+
+	if w := serve(post("team/k", "v")); w.Code != http.StatusForbidden {
+		t.Fatalf("POST without the namespace secret: got %d, want 403", w.Code)
+	}
+	r := post("team/k", "v")
+	r.Header.Set("X-Owner-Secret", "s")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("POST with the namespace secret: got %d", w.Code)
+	}
+	// The key itself stays unowned, the namespace secret is never stored per key
+	if entry, _ := kvMap.Get("team/k"); entry.Secret != "" {
+		t.Fatal("namespace secret registered as the key's secret")
+	}
+
+	del := httptest.NewRequest(http.MethodDelete, "/team/k", nil)
+	del.Header.Set("X-Owner-Secret", "wrong")
+	if w := serve(del); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE with a wrong secret: got %d, want 403", w.Code)
+	}
+	del.Header.Set("X-Owner-Secret", "s")
+	if w := serve(del); w.Code != http.StatusOK {
+		t.Fatalf("DELETE with the namespace secret: got %d", w.Code)
+	}
+
+	// Reads are not affected
+	r = post("team/k", "v")
+	r.Header.Set("X-Owner-Secret", "s")
+	serve(r)
+	if w := serve(httptest.NewRequest(http.MethodGet, "/team/k", nil)); w.Code != http.StatusOK {
+		t.Fatalf("GET in a secret namespace: got %d", w.Code)
+	}
+}
+
+func TestNamespaceMaxNumKV(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "namespaces", "cap/:maxNumKV=2;cap/sub/:maxValueSize=8")
+	setFlag(t, "recordCreatorIP", "true")
+
+	for _, key := range []string{"cap/a", "cap/b"} {
+		if w := serve(post(key, "v")); w.Code != http.StatusOK {
+			t.Fatalf("POST %s: got %d", key, w.Code)
+		}
+	}
+	if w := serve(post("cap/c", "v")); w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("POST to a full namespace: got %d, want 507", w.Code)
+	}
+	// Nested namespaces count against the top-level cap
+	if w := serve(post("cap/sub/c", "v")); w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("POST to a nested namespace of a full one: got %d, want 507", w.Code)
+	}
+	// Existing keys can still be updated, and other prefixes have no cap
+	if w := serve(post("cap/a", "w")); w.Code != http.StatusOK {
+		t.Fatalf("update in a full namespace: got %d", w.Code)
+	}
+	if w := serve(post("free", "v")); w.Code != http.StatusOK {
+		t.Fatalf("POST outside the namespace: got %d", w.Code)
+	}
+
+	// Deleting a key frees its slot
+	if w := serve(httptest.NewRequest(http.MethodDelete, "/cap/b", nil)); w.Code != http.StatusOK {
+		t.Fatalf("DELETE cap/b: got %d", w.Code)
+	}
+	if w := serve(post("cap/c", "v")); w.Code != http.StatusOK {
+		t.Fatalf("POST after freeing a slot: got %d", w.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// Operations selected by the X-Op header on POST
+const (
+	opSet       = ""          // replace the value (default)
+	opIncrement = "increment" // treat the value as an integer counter
+	opAppend    = "append"    // append the body to the existing value
+)
+
+var errNotInteger = errors.New("Value is not an integer")
+
+// validOp reports whether op is a supported X-Op value
+func validOp(op string) bool {
+	switch op {
+	case opSet, opIncrement, opAppend:
+		return true
+	}
+	return false
+}
+
+// applyOp computes the new value of a key from the request body according to op.
+// current is nil if the key does not exist yet, maxSize limits the resulting value.
+// Called inside an atomic store update, so it must be fast.
+func applyOp(op string, current *Entry, body []byte, maxSize int) ([]byte, error) {
+	switch op {
+	case opAppend:
+		// Appending to a missing key creates it
+		if current == nil {
+			return body, nil
+		}
+		currentValue := current.value()
+		if len(currentValue)+len(body) > maxSize {
+			return nil, errors.New("Value too large after append")
+		}
+		value := make([]byte, 0, len(currentValue)+len(body))
+		value = append(value, currentValue...)
+		return append(value, body...), nil
+	case opIncrement:
+		// Empty body means +1, missing keys start at 0
+		delta := int64(1)
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 {
+			var err error
+			if delta, err = strconv.ParseInt(string(trimmed), 10, 64); err != nil {
+				return nil, errors.New("Invalid increment")
+			}
+		}
+		var value int64
+		if current != nil {
+			var err error
+			if value, err = strconv.ParseInt(string(bytes.TrimSpace(current.value())), 10, 64); err != nil {
+				return nil, errNotInteger
+			}
+		}
+		if (delta > 0 && value > value+delta) || (delta < 0 && value < value+delta) {
+			return nil, errors.New("Counter overflow")
+		}
+		return []byte(strconv.FormatInt(value+delta, 10)), nil
+	default:
+		return body, nil
+	}
+}
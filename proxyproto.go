@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout limits how long a proxy may take to send the PROXY protocol header
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener wraps accepted connections to read the PROXY protocol header (-proxyProtocol)
+type proxyListener struct {
+	net.Listener
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn}, nil
+}
+
+// proxyConn reports the client address from the PROXY protocol header as its remote address.
+// The header is read lazily on first use, so a slow proxy doesn't block the accept loop.
+// Only connections from trusted proxies and unix sockets are expected to send it, others are served as is.
+type proxyConn struct {
+	net.Conn
+	once   sync.Once
+	reader *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		c.remote = c.Conn.RemoteAddr()
+		switch addr := c.remote.(type) {
+		case *net.UnixAddr:
+			// Unix socket peers are local and trusted
+		case *net.TCPAddr:
+			if !isTrustedProxy(addr.IP) {
+				return
+			}
+		default:
+			return
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		addr, err := readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			c.err = err
+			c.Conn.Close()
+			return
+		}
+		if addr != nil {
+			c.remote = addr
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readProxyHeader reads a v1 or v2 PROXY protocol header and returns the client address.
+// A nil address means the proxy did not pass one (UNKNOWN or LOCAL), e.g. for health checks.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	if start, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(start, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	start, err := r.Peek(6)
+	if err != nil {
+		return nil, err
+	}
+	if string(start) != "PROXY " {
+		return nil, errors.New("missing PROXY protocol header")
+	}
+	return readProxyHeaderV1(r)
+}
+
+// readProxyHeaderV1 parses the text header: "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// The spec limits the line to 107 bytes
+	var line []byte
+	for len(line) <= 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("invalid PROXY protocol v1 header")
+	}
+	fields := strings.Fields(text)
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid PROXY protocol v1 header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errors.New("invalid PROXY protocol v1 address")
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 parses the binary header
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	version, command, family := header[12]>>4, header[12]&0xF, header[13]
+	if version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	// LOCAL connections are made by the proxy itself
+	if command == 0 {
+		return nil, nil
+	}
+	if command != 1 {
+		return nil, fmt.Errorf("unsupported PROXY protocol command %d", command)
+	}
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errors.New("invalid PROXY protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("invalid PROXY protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	// Other families (UDP, unix sockets) carry no usable client address
+	return nil, nil
+}
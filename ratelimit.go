@@ -0,0 +1,231 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucket holds the rate limit state of a single client.
+// Tokens are refilled gradually at maxRequests per *resetDuration.
+type bucket struct {
+	tokens     float64
+	lastRefill time.Time
+	seen       *list.Element // position in rateLimitLRU
+}
+
+var (
+	// rateLimit is a map storing the token bucket per IP (or IPv6 prefix)
+	rateLimit = make(map[[16]byte]*bucket)
+	// rateLimitLRU holds the keys of rateLimit, most recently seen first, for -maxTrackedIPs
+	rateLimitLRU = list.New()
+	// mu protects rateLimit and rateLimitLRU
+	mu sync.Mutex
+)
+
+// trackBucket adds the bucket of a new client, forgetting the least recently seen ones
+// beyond -maxTrackedIPs. They start over with a full bucket. Caller must hold mu.
+func trackBucket(key [16]byte, b *bucket) {
+	for *maxTrackedIPs > 0 && rateLimitLRU.Len() >= *maxTrackedIPs {
+		forgetBucket(rateLimitLRU.Back().Value.([16]byte))
+	}
+	b.seen = rateLimitLRU.PushFront(key)
+	rateLimit[key] = b
+}
+
+// forgetBucket removes the client's bucket. Caller must hold mu.
+func forgetBucket(key [16]byte) {
+	if b, exists := rateLimit[key]; exists {
+		rateLimitLRU.Remove(b.seen)
+		delete(rateLimit, key)
+	}
+}
+
+// rateLimitKey returns the key under which the client is rate limited.
+// IPv4 addresses are used as is, IPv6 addresses are truncated to *ipv6Prefix bits,
+// since a single client usually controls a whole /64 and could rotate addresses.
+func rateLimitKey(ip net.IP) [16]byte {
+	var key [16]byte
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(key[:], ip4.To16())
+		return key
+	}
+	copy(key[:], ip.Mask(net.CIDRMask(*ipv6Prefix, 128)))
+	return key
+}
+
+// requestCost returns the number of tokens consumed by a request with the given method
+func requestCost(method string) float64 {
+	cfg := currentConfig()
+	switch method {
+	case http.MethodPost:
+		return float64(cfg.postCost)
+	case http.MethodDelete:
+		return float64(cfg.deleteCost)
+	default:
+		return float64(cfg.getCost)
+	}
+}
+
+// bucketCapacity returns the maximum number of tokens a bucket can hold
+func bucketCapacity() float64 {
+	return float64(currentConfig().maxRequests)
+}
+
+// refillRate returns the number of tokens added per second to a bucket of the given capacity
+func refillRate(capacity float64) float64 {
+	return capacity / resetDuration.Seconds()
+}
+
+// refill adds tokens proportional to the time elapsed since the last refill, up to the capacity
+func (b *bucket) refill(now time.Time, capacity float64) {
+	b.tokens += now.Sub(b.lastRefill).Seconds() * refillRate(capacity)
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.lastRefill = now
+}
+
+// takeTokens refills the client's bucket and tries to consume cost tokens from it.
+// Returns the tokens left in the bucket, and if the client doesn't have enough
+// tokens, false along with the time after which the bucket will have refilled enough.
+func takeTokens(key [16]byte, cost float64) (ok bool, remaining float64, wait time.Duration) {
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	b, exists := rateLimit[key]
+	if !exists {
+		// Unknown clients start with a full bucket
+		b = &bucket{tokens: bucketCapacity(), lastRefill: now}
+		trackBucket(key, b)
+	} else {
+		b.refill(now, bucketCapacity())
+		rateLimitLRU.MoveToFront(b.seen)
+	}
+	if b.tokens < cost {
+		wait = time.Duration((cost - b.tokens) / refillRate(bucketCapacity()) * float64(time.Second))
+		return false, b.tokens, wait
+	}
+	b.tokens -= cost
+	return true, b.tokens, 0
+}
+
+// pruneRateLimit periodically forgets clients whose buckets have been refilled completely,
+// since they are indistinguishable from clients that were never seen. Stops when ctx is cancelled.
+func pruneRateLimit(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*resetDuration):
+		}
+		now := time.Now()
+		mu.Lock()
+		for key, b := range rateLimit {
+			b.refill(now, bucketCapacity())
+			if b.tokens >= bucketCapacity() {
+				forgetBucket(key)
+			}
+		}
+		mu.Unlock()
+		pruneKeyRateLimit(now)
+	}
+}
+
+var (
+	// keyRateLimit is a map storing the token bucket per key, for -maxKeyUpdates
+	keyRateLimit = make(map[string]*bucket)
+	// keyRateLimitMu protects keyRateLimit
+	keyRateLimitMu sync.Mutex
+)
+
+// takeKeyToken consumes one update of the key from its bucket, holding -maxKeyUpdates per *resetDuration.
+// Returns false along with the time until the next update is allowed if the key is updated too often.
+func takeKeyToken(key string) (ok bool, wait time.Duration) {
+	capacity := float64(*maxKeyUpdates)
+	now := time.Now()
+	keyRateLimitMu.Lock()
+	defer keyRateLimitMu.Unlock()
+	b, exists := keyRateLimit[key]
+	if !exists {
+		b = &bucket{tokens: capacity, lastRefill: now}
+		keyRateLimit[key] = b
+	} else {
+		b.refill(now, capacity)
+	}
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / refillRate(capacity) * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// pruneKeyRateLimit forgets keys whose buckets have been refilled completely
+func pruneKeyRateLimit(now time.Time) {
+	capacity := float64(*maxKeyUpdates)
+	keyRateLimitMu.Lock()
+	defer keyRateLimitMu.Unlock()
+	for key, b := range keyRateLimit {
+		b.refill(now, capacity)
+		if b.tokens >= capacity {
+			delete(keyRateLimit, key)
+		}
+	}
+}
+
+// savedBucket is the state of a bucket kept across restarts with -persistRateLimit
+type savedBucket struct {
+	Tokens     float64 `json:"t"`
+	LastRefill int64   `json:"r"` // unix nanoseconds
+}
+
+// saveRateLimits stores the buckets that are not full, the rest are indistinguishable
+// from clients that were never seen
+func saveRateLimits() {
+	now := time.Now()
+	buckets := make(map[string]savedBucket)
+	mu.Lock()
+	for key, b := range rateLimit {
+		b.refill(now, bucketCapacity())
+		if b.tokens < bucketCapacity() {
+			buckets[hex.EncodeToString(key[:])] = savedBucket{Tokens: b.tokens, LastRefill: b.lastRefill.UnixNano()}
+		}
+	}
+	mu.Unlock()
+	kvMap.SaveRateLimits(buckets)
+}
+
+// loadRateLimits restores the buckets saved on the last shutdown, they are refilled
+// for the downtime on the next request like any other bucket
+func loadRateLimits() {
+	mu.Lock()
+	defer mu.Unlock()
+	for keyHex, saved := range kvMap.LoadRateLimits() {
+		decoded, err := hex.DecodeString(keyHex)
+		if err != nil || len(decoded) != 16 {
+			continue
+		}
+		trackBucket([16]byte(decoded), &bucket{tokens: saved.Tokens, lastRefill: time.Unix(0, saved.LastRefill)})
+	}
+}
+
+// setRateLimitHeaders exposes the client's bucket state for debugging rate limit handling
+func setRateLimitHeaders(w http.ResponseWriter, remaining float64) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(currentConfig().maxRequests))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Floor(remaining))))
+}
+
+// setRetryAfter sets the Retry-After header, rounding up to whole seconds
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// allowed reports whether takeTokens lets a request of the given cost through
+func allowed(key [16]byte, cost float64) bool {
+	ok, _, _ := takeTokens(key, cost)
+	return ok
+}
+
+// rewind moves the client's last refill into the past, as if d had elapsed since
+func rewind(key [16]byte, d time.Duration) {
+	mu.Lock()
+	rateLimit[key].lastRefill = rateLimit[key].lastRefill.Add(-d)
+	mu.Unlock()
+}
+
+func TestTakeTokensRefill(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "maxRequests", "6")
+	setFlag(t, "resetDuration", "1m")
+	key := rateLimitKey(net.ParseIP("192.0.2.1"))
+
+	// A new client starts with a full bucket
+	for i := 0; i < 2; i++ {
+		if !allowed(key, 3) {
+			t.Fatalf("request %d rejected with a full bucket", i+1)
+		}
+	}
+	if allowed(key, 3) {
+		t.Fatal("request allowed with an empty bucket")
+	}
+
+	// Half of resetDuration refills half of the bucket instead of resetting it
+	rewind(key, 30*time.Second)
+	if !allowed(key, 3) {
+		t.Fatal("tokens not refilled after half of resetDuration")
+	}
+	if allowed(key, 1) {
+		t.Fatal("more tokens refilled than elapsed time allows")
+	}
+
+	// An idle client never gets more than the capacity
+	rewind(key, time.Hour)
+	for i := 0; i < 6; i++ {
+		if !allowed(key, 1) {
+			t.Fatalf("token %d missing after a long idle period", i+1)
+		}
+	}
+	if allowed(key, 1) {
+		t.Fatal("bucket refilled beyond maxRequests")
+	}
+}
+
+func TestRateLimitedRequest(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "maxRequests", "4")
+
+	// POST costs 3 tokens and GET 1, so one of each fits in the bucket
+	if w := serve(post("k", "v")); w.Code != http.StatusOK {
+		t.Fatalf("first POST: got %d", w.Code)
+	}
+	if w := serve(post("k", "v")); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("POST over the limit: got %d, want 429", w.Code)
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/k", nil)); w.Code != http.StatusOK {
+		t.Fatalf("GET within the limit: got %d", w.Code)
+	}
+
+	// Other clients have their own buckets
+	r := post("k", "w")
+	r.RemoteAddr = "192.0.2.2:1234"
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("POST from another client: got %d", w.Code)
+	}
+}
+
+func TestMaxKeyUpdates(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "maxKeyUpdates", "1")
+	setFlag(t, "resetDuration", "1m")
+
+	if w := serve(post("k", "v")); w.Code != http.StatusOK {
+		t.Fatalf("first POST: got %d", w.Code)
+	}
+	// The key's bucket is shared by all clients
+	r := post("k", "w")
+	r.RemoteAddr = "192.0.2.2:1234"
+	w := serve(r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("second POST to the key: got %d with Retry-After %q, want 429 with 60", w.Code, w.Header().Get("Retry-After"))
+	}
+	if entry, _ := kvMap.Get("k"); string(entry.Value) != "v" {
+		t.Fatalf("rate limited POST stored %q", entry.Value)
+	}
+	if w := serve(post("other", "v")); w.Code != http.StatusOK {
+		t.Fatalf("POST to another key: got %d", w.Code)
+	}
+	// Reads aren't limited per key
+	if w := serve(httptest.NewRequest(http.MethodGet, "/k", nil)); w.Code != http.StatusOK {
+		t.Fatalf("GET of a rate limited key: got %d", w.Code)
+	}
+}
+
+func TestMaxKeyUpdatesIPNamespace(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "maxKeyUpdates", "1")
+
+	// Each client writes under its own address, but all of them requested the same key
+	serve(post("ip/peer", "v"))
+	r := post("ip/peer", "v")
+	r.RemoteAddr = "192.0.2.2:1234"
+	if w := serve(r); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second client POSTing ip/peer: got %d, want 429", w.Code)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "maxRequests", "6")
+	setFlag(t, "resetDuration", "1m")
+
+	for i := 0; i < 2; i++ {
+		serve(post("k", "v"))
+	}
+	// 3 missing tokens at 6 tokens per minute take 30 seconds
+	w := serve(post("k", "v"))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("got %d with Retry-After %q, want 429 with 30", w.Code, w.Header().Get("Retry-After"))
+	}
+	// A GET needs only one token, so it can retry sooner
+	w = serve(httptest.NewRequest(http.MethodGet, "/k", nil))
+	if w.Header().Get("Retry-After") != "10" {
+		t.Fatalf("GET Retry-After %q, want 10", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestSetRetryAfterRounding(t *testing.T) {
+	for wait, want := range map[time.Duration]string{
+		0:                       "1",
+		time.Millisecond:        "1",
+		1500 * time.Millisecond: "2",
+		30 * time.Second:        "30",
+	} {
+		w := httptest.NewRecorder()
+		setRetryAfter(w, wait)
+		if got := w.Header().Get("Retry-After"); got != want {
+			t.Errorf("setRetryAfter(%v) = %q, want %q", wait, got, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+)
+
+// Prefix marking a salted secret hash, secrets stored without it are legacy plaintext
+const secretHashPrefix = "sha256:"
+
+// hashSecret returns a salted SHA-256 hash of the secret in the form "sha256:<salt>:<hash>"
+func hashSecret(secret string) string {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return secretHashPrefix + base64.RawStdEncoding.EncodeToString(salt) + ":" + saltedHash(salt, secret)
+}
+
+// saltedHash returns the base64 encoded SHA-256 of salt+secret
+func saltedHash(salt []byte, secret string) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// isHashedSecret reports whether a stored secret is already hashed
+func isHashedSecret(stored string) bool {
+	return strings.HasPrefix(stored, secretHashPrefix)
+}
+
+// validSecretHash reports whether s is a well-formed hash as produced by hashSecret
+func validSecretHash(s string) bool {
+	saltStr, hash, ok := strings.Cut(strings.TrimPrefix(s, secretHashPrefix), ":")
+	if !ok || !isHashedSecret(s) {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(saltStr)
+	if err != nil || len(salt) == 0 {
+		return false
+	}
+	digest, err := base64.RawStdEncoding.DecodeString(hash)
+	return err == nil && len(digest) == sha256.Size
+}
+
+// checkSecret reports whether the provided secret matches the stored one.
+// Comparisons are constant-time to avoid leaking the secret through response timing.
+func checkSecret(stored, provided string) bool {
+	if !isHashedSecret(stored) {
+		// Legacy plaintext secret. ConstantTimeCompare returns early on length mismatch,
+		// so compare fixed-size digests instead to not leak the secret length.
+		a := sha256.Sum256([]byte(stored))
+		b := sha256.Sum256([]byte(provided))
+		return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+	}
+	saltStr, hash, ok := strings.Cut(strings.TrimPrefix(stored, secretHashPrefix), ":")
+	if !ok {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(saltStr)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(saltedHash(salt, provided)), []byte(hash)) == 1
+}
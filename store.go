@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// storeBytes is the total size of all stored values, kept up to date by every store mutation
+var storeBytes atomic.Int64
+
+var (
+	// creatorKeys counts stored keys per creator address, grouped like rate limiting, for -maxKeysPerIP
+	creatorKeys = make(map[[16]byte]int)
+	// creatorKeysMu protects creatorKeys
+	creatorKeysMu sync.Mutex
+)
+
+var (
+	// prefixKeys counts stored keys per top-level prefix, for the maxNumKV namespace option
+	prefixKeys = make(map[string]int)
+	// prefixKeysMu protects prefixKeys
+	prefixKeysMu sync.Mutex
+)
+
+// topLevelPrefix returns the key up to and including the first "/", "" for keys without one
+func topLevelPrefix(key string) string {
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		return key[:i+1]
+	}
+	return ""
+}
+
+// valueSize returns the size of the entry's value, 0 for nil
+func valueSize(entry *Entry) int64 {
+	if entry == nil {
+		return 0
+	}
+	return int64(len(entry.Value))
+}
+
+// trackEntry accounts for the key's entry being replaced; old or new is nil on creation/deletion
+func trackEntry(key string, old, new *Entry) {
+	storeBytes.Add(valueSize(new) - valueSize(old))
+	if (old == nil) != (new == nil) {
+		delta := 1
+		if new == nil {
+			delta = -1
+		}
+		prefixKeysMu.Lock()
+		if prefixKeys[topLevelPrefix(key)] += delta; prefixKeys[topLevelPrefix(key)] <= 0 {
+			delete(prefixKeys, topLevelPrefix(key))
+		}
+		prefixKeysMu.Unlock()
+	}
+	if old == nil || new == nil || old.CreatorIP != new.CreatorIP {
+		countCreatorKey(old, -1)
+		countCreatorKey(new, 1)
+	}
+}
+
+// countCreatorKey adjusts the number of keys created from the entry's creator address
+func countCreatorKey(entry *Entry, delta int) {
+	if entry == nil || entry.CreatorIP == "" {
+		return
+	}
+	ip := net.ParseIP(entry.CreatorIP)
+	if ip == nil {
+		return
+	}
+	key := rateLimitKey(ip)
+	creatorKeysMu.Lock()
+	defer creatorKeysMu.Unlock()
+	if creatorKeys[key] += delta; creatorKeys[key] <= 0 {
+		delete(creatorKeys, key)
+	}
+}
+
+// sameCreator reports whether ip belongs to the same address group as the entry's creator
+func sameCreator(entry *Entry, ip net.IP) bool {
+	creator := net.ParseIP(entry.CreatorIP)
+	return creator != nil && rateLimitKey(creator) == rateLimitKey(ip)
+}
+
+// keyQuotaReached reports whether the client already created *maxKeysPerIP keys that still exist
+func keyQuotaReached(ip net.IP) bool {
+	if *maxKeysPerIP <= 0 {
+		return false
+	}
+	key := rateLimitKey(ip)
+	creatorKeysMu.Lock()
+	defer creatorKeysMu.Unlock()
+	return creatorKeys[key] >= *maxKeysPerIP
+}
+
+// countStoreUsage initializes storeBytes, creatorKeys and prefixKeys from the loaded store
+func countStoreUsage() {
+	storeBytes.Store(0)
+	kvMap.Range(func(key string, entry *Entry) bool {
+		trackEntry(key, nil, entry)
+		return true
+	})
+}
+
+// setKey stores the entry, replacing any existing value
+func setKey(key string, entry *Entry) {
+	kvMap.Update(key, func(upd *entryUpdate) {
+		if upd.Exists {
+			trackEntry(key, upd.Value, entry)
+		} else {
+			trackEntry(key, nil, entry)
+		}
+		upd.Set(entry)
+	})
+}
+
+// deleteKey removes the key, returning the removed entry
+func deleteKey(key string) (removed *Entry, existed bool) {
+	kvMap.Update(key, func(upd *entryUpdate) {
+		if !upd.Exists {
+			upd.Cancel()
+			return
+		}
+		removed, existed = upd.Value, true
+		trackEntry(key, upd.Value, nil)
+		upd.Delete()
+	})
+	return
+}
+
+// getEntry returns the entry stored under key unless it has expired.
+// Expired entries not yet removed by cleanupExpiredKeys are deleted on the spot.
+func getEntry(key string) (*Entry, bool) {
+	entry, exists := kvMap.Get(key)
+	if !exists || !entry.expired(key, time.Now()) {
+		return entry, exists
+	}
+	kvMap.Update(key, func(upd *entryUpdate) {
+		// The key may have been updated since it was read
+		if !upd.Exists || !upd.Value.expired(key, time.Now()) {
+			upd.Cancel()
+			return
+		}
+		trackEntry(key, upd.Value, nil)
+		upd.Delete()
+		expiredKeysTotal.Add(1)
+	})
+	return nil, false
+}
+
+// namespaceFull reports whether the top-level namespace of the key already holds its maxNumKV keys.
+// The cap is taken from the top-level namespace even if a nested one matches the key,
+// since keys of nested namespaces are counted towards their top-level prefix.
+func namespaceFull(key string) (full bool, limit int) {
+	prefix := topLevelPrefix(key)
+	for _, ns := range currentConfig().namespaces {
+		if ns.prefix == prefix {
+			limit = ns.maxNumKV
+			break
+		}
+	}
+	if limit <= 0 {
+		return false, 0
+	}
+	prefixKeysMu.Lock()
+	defer prefixKeysMu.Unlock()
+	return prefixKeys[prefix] >= limit, limit
+}
+
+// namespaceCapacityMessage describes a new key refused because its namespace is full
+func namespaceCapacityMessage(limit int) string {
+	return fmt.Sprintf("Namespace capacity reached: limit is %d keys", limit)
+}
+
+// evictionCandidate is a key remembered by the last eviction scan along with its update time
+type evictionCandidate struct {
+	key        string
+	lastUpdate int64
+}
+
+// evictionBatch is how many of the oldest keys a single scan remembers
+const evictionBatch = 64
+
+var (
+	evictionMu         sync.Mutex
+	evictionCandidates []evictionCandidate // oldest first
+)
+
+// scanEvictionCandidates ranges over the store once and returns the oldest keys, oldest first
+func scanEvictionCandidates() []evictionCandidate {
+	var candidates []evictionCandidate
+	kvMap.Range(func(key string, entry *Entry) bool {
+		if len(candidates) == evictionBatch && entry.LastUpdate >= candidates[len(candidates)-1].lastUpdate {
+			return true
+		}
+		i := sort.Search(len(candidates), func(i int) bool {
+			return candidates[i].lastUpdate > entry.LastUpdate
+		})
+		if len(candidates) < evictionBatch {
+			candidates = append(candidates, evictionCandidate{})
+		}
+		copy(candidates[i+1:], candidates[i:])
+		candidates[i] = evictionCandidate{key, entry.LastUpdate}
+		return true
+	})
+	return candidates
+}
+
+// evictOldest deletes the least recently updated key other than exclude.
+// The oldest keys are remembered between calls so that evicting near capacity doesn't
+// range over the whole store every time; a remembered key that was updated since is skipped,
+// so the choice is approximate. Returns false if there is nothing to evict.
+func evictOldest(exclude string) bool {
+	evictionMu.Lock()
+	defer evictionMu.Unlock()
+	rescanned := false
+	for {
+		if len(evictionCandidates) == 0 {
+			if rescanned {
+				return false
+			}
+			evictionCandidates = scanEvictionCandidates()
+			rescanned = true
+			continue
+		}
+		candidate := evictionCandidates[0]
+		evictionCandidates = evictionCandidates[1:]
+		if candidate.key == exclude {
+			continue
+		}
+		entry, exists := kvMap.Get(candidate.key)
+		if !exists || entry.LastUpdate != candidate.lastUpdate {
+			continue
+		}
+		if _, existed := deleteKey(candidate.key); existed {
+			evictedKeysTotal.Add(1)
+			notifyKey(candidate.key)
+		}
+		return true
+	}
+}
+
+// ensureKeySlot evicts the least recently updated keys until a new key fits into *maxNumKV.
+// Only applies with -evictionPolicy=lru. Returns false if the store is still full
+// or ctx was cancelled before enough room was made.
+func ensureKeySlot(ctx context.Context, key string) bool {
+	if _, exists := kvMap.Get(key); exists {
+		return true
+	}
+	for kvMap.Size() >= *maxNumKV {
+		if *evictionPolicy != "lru" || ctx.Err() != nil || !evictOldest(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureStoreBytes evicts the least recently updated keys until a value of the given size
+// fits into *maxStoreBytes alongside the others. The key being written is never evicted.
+// Returns false if the value can't fit even after eviction, or ctx was cancelled.
+func ensureStoreBytes(ctx context.Context, key string, size int64) bool {
+	if *maxStoreBytes <= 0 {
+		return true
+	}
+	if size > *maxStoreBytes {
+		return false
+	}
+	current, _ := kvMap.Get(key)
+	for storeBytes.Load()-valueSize(current)+size > *maxStoreBytes {
+		if ctx.Err() != nil || !evictOldest(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// fitsStoreBytes reports whether replacing old with new stays within *maxStoreBytes
+func fitsStoreBytes(old, new *Entry) bool {
+	return *maxStoreBytes <= 0 || storeBytes.Load()-valueSize(old)+valueSize(new) <= *maxStoreBytes
+}
+
+// keyCapacityMessage describes a write refused because the store holds *maxNumKV keys
+func keyCapacityMessage() string {
+	return fmt.Sprintf("Store capacity reached: limit is %d keys", *maxNumKV)
+}
+
+// byteCapacityMessage describes a write refused because values would exceed *maxStoreBytes
+func byteCapacityMessage() string {
+	return fmt.Sprintf("Store size limit reached: limit is %d bytes", *maxStoreBytes)
+}
+
+// capacityRetryAfter is the Retry-After for writes refused by a full store.
+// Unlike an oversized value this is transient, room is made when keys expire,
+// so clients are told to retry after the next expiry cleanup.
+func capacityRetryAfter() time.Duration {
+	if *cleanupInterval > 0 {
+		return *cleanupInterval
+	}
+	return autoCleanupInterval(configuredExpiration())
+}
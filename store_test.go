@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// postFrom builds a POST of value to key sent from the given client address
+func postFrom(client, key, value string) *http.Request {
+	r := post(key, value)
+	r.RemoteAddr = client
+	return r
+}
+
+func TestMaxKeysPerIP(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "maxKeysPerIP", "2")
+	const client, other = "192.0.2.1:1234", "192.0.2.2:1234"
+	for _, key := range []string{"a", "b"} {
+		r := postFrom(client, key, "v")
+		r.Header.Set("X-Owner-Secret", "s")
+		if w := serve(r); w.Code != http.StatusOK {
+			t.Fatalf("POST %s within the quota: got %d", key, w.Code)
+		}
+	}
+	if w := serve(postFrom(client, "c", "v")); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("POST over the quota: got %d, want 429", w.Code)
+	}
+	if _, exists := kvMap.Get("c"); exists {
+		t.Fatal("key over the quota was stored")
+	}
+
+	// The quota limits new keys only, updates and other clients are unaffected
+	r := postFrom(client, "a", "w")
+	r.Header.Set("X-Owner-Secret", "s")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("update at the quota: got %d", w.Code)
+	}
+	if w := serve(postFrom(other, "c", "v")); w.Code != http.StatusOK {
+		t.Fatalf("POST from another client: got %d", w.Code)
+	}
+
+	// Deleting a key frees its slot
+	r = httptest.NewRequest(http.MethodDelete, "/a", nil)
+	r.Header.Set("X-Owner-Secret", "s")
+	serve(r)
+	if w := serve(postFrom(client, "d", "v")); w.Code != http.StatusOK {
+		t.Fatalf("POST after deleting a key: got %d", w.Code)
+	}
+}
+
+func TestMaxKeysPerIPGroupsIPv6(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "maxKeysPerIP", "1")
+	serve(postFrom("[2001:db8::1]:1234", "a", "v"))
+	// Addresses of the same /64 belong to one client
+	if w := serve(postFrom("[2001:db8::2]:1234", "b", "v")); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("POST from the same /64: got %d, want 429", w.Code)
+	}
+	if w := serve(postFrom("[2001:db8:0:1::1]:1234", "b", "v")); w.Code != http.StatusOK {
+		t.Fatalf("POST from another /64: got %d", w.Code)
+	}
+}
+
+func TestMaxKeysPerIPTakeOver(t *testing.T) {
+	newTestStore(t)
+	setFlag(t, "maxKeysPerIP", "1")
+	const client, other = "192.0.2.1:1234", "192.0.2.2:1234"
+	serve(postFrom(client, "a", "v"))
+	serve(postFrom(other, "b", "v"))
+
+	// Overwriting an unowned key would make it the writer's, beyond their quota
+	if w := serve(postFrom(other, "a", "w")); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("overwrite over the quota: got %d, want 429", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodDelete, "/b", nil)
+	r.RemoteAddr = other
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("DELETE by the creator: got %d", w.Code)
+	}
+	if w := serve(postFrom(other, "a", "w")); w.Code != http.StatusOK {
+		t.Fatalf("overwrite within the quota: got %d", w.Code)
+	}
+	// The key moved to the writer, freeing the original creator's slot
+	if entry, _ := kvMap.Get("a"); entry.CreatorIP != "192.0.2.2" {
+		t.Fatalf("creator after overwrite is %q", entry.CreatorIP)
+	}
+	if w := serve(postFrom(client, "c", "v")); w.Code != http.StatusOK {
+		t.Fatalf("POST by the former creator: got %d", w.Code)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newCertManager creates an autocert manager that obtains and renews
+// Let's Encrypt certificates for the hostnames listed in *autocertDomain
+func newCertManager() *autocert.Manager {
+	var hosts []string
+	for _, host := range strings.Split(*autocertDomain, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(*autocertDir),
+	}
+}
+
+// redirectToHTTPS answers every request with a 301 to the same URL over HTTPS on tlsPort
+func redirectToHTTPS(tlsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
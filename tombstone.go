@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// tombstones remembers when keys were deleted by their owners, for -tombstoneTTL
+	tombstones = make(map[string]time.Time)
+	// tombstonesMu protects tombstones
+	tombstonesMu sync.Mutex
+)
+
+// addTombstone records that the key was just deleted
+func addTombstone(key string) {
+	if *tombstoneTTL <= 0 {
+		return
+	}
+	tombstonesMu.Lock()
+	defer tombstonesMu.Unlock()
+	tombstones[key] = time.Now()
+}
+
+// clearTombstone forgets the deletion of a key that was stored again
+func clearTombstone(key string) {
+	if *tombstoneTTL <= 0 {
+		return
+	}
+	tombstonesMu.Lock()
+	defer tombstonesMu.Unlock()
+	delete(tombstones, key)
+}
+
+// recentlyDeleted reports whether the key was deleted less than -tombstoneTTL ago
+func recentlyDeleted(key string) bool {
+	if *tombstoneTTL <= 0 {
+		return false
+	}
+	tombstonesMu.Lock()
+	defer tombstonesMu.Unlock()
+	deleted, ok := tombstones[key]
+	return ok && time.Since(deleted) < *tombstoneTTL
+}
+
+// pruneTombstones removes tombstones older than -tombstoneTTL
+func pruneTombstones() {
+	tombstonesMu.Lock()
+	defer tombstonesMu.Unlock()
+	for key, deleted := range tombstones {
+		if time.Since(deleted) >= *tombstoneTTL {
+			delete(tombstones, key)
+		}
+	}
+}
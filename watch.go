@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// watcher is a notification channel shared by everyone waiting for a key to change
+type watcher struct {
+	ch   chan struct{} // closed on the next change of the key
+	refs int           // number of active waiters
+}
+
+var (
+	// watchers maps keys to their current watcher, only while someone is waiting
+	watchers = make(map[string]*watcher)
+	// watchersMu protects watchers
+	watchersMu sync.Mutex
+)
+
+// watchKey returns a channel that is closed on the next change of the key,
+// and a function that must be called once the caller stops waiting
+func watchKey(key string) (<-chan struct{}, func()) {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	wt, exists := watchers[key]
+	if !exists {
+		wt = &watcher{ch: make(chan struct{})}
+		watchers[key] = wt
+	}
+	wt.refs++
+	return wt.ch, func() {
+		watchersMu.Lock()
+		defer watchersMu.Unlock()
+		wt.refs--
+		// Forget the watcher when nobody waits anymore, unless it was already replaced by notifyKey
+		if wt.refs == 0 && watchers[key] == wt {
+			delete(watchers, key)
+		}
+	}
+}
+
+var (
+	// draining is closed once the server starts shutting down, held requests end early
+	draining     = make(chan struct{})
+	drainingOnce sync.Once
+)
+
+// startDraining releases all held long-poll and SSE requests
+func startDraining() {
+	drainingOnce.Do(func() { close(draining) })
+}
+
+// notifyKey wakes up everyone waiting for the key to change
+func notifyKey(key string) {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	if wt, exists := watchers[key]; exists {
+		close(wt.ch)
+		delete(watchers, key)
+	}
+}
+
+// parseWait parses the ?wait= query parameter, either a Go duration or a number of seconds
+func parseWait(s string) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(seconds) * time.Second, seconds >= 0
+	}
+	d, err := time.ParseDuration(s)
+	return d, err == nil && d >= 0
+}
+
+// waitForChange holds a GET request until the key exists and differs from the version
+// in the client's If-None-Match header, or until the timeout elapses.
+// Returns the current entry, or ok=false if the client went away while waiting.
+func waitForChange(w http.ResponseWriter, r *http.Request, key string, timeout time.Duration) (entry *Entry, exists bool, ok bool) {
+	// Held requests would otherwise be killed by the server's WriteTimeout
+	if *writeTimeout > 0 {
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + *writeTimeout))
+	}
+
+	inm := r.Header.Get("If-None-Match")
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		// Subscribe before reading the key, so an update between the two isn't missed
+		changed, release := watchKey(key)
+		entry, exists = getEntry(key)
+		if exists && (inm == "" || !etagMatches(inm, entry)) {
+			release()
+			return entry, exists, true
+		}
+		select {
+		case <-changed:
+			release()
+		case <-timer.C:
+			release()
+			return entry, exists, true
+		case <-draining:
+			release()
+			return entry, exists, true
+		case <-r.Context().Done():
+			release()
+			return nil, false, false
+		}
+	}
+}
+
+var (
+	// heldRequests counts long-poll and SSE requests in progress per client
+	heldRequests = make(map[[16]byte]int)
+	// heldRequestsMu protects heldRequests
+	heldRequestsMu sync.Mutex
+)
+
+// acquireHold registers a long-lived request of the client, the returned release must be
+// called when it ends. Returns false if the client already holds *maxHeldPerIP requests.
+func acquireHold(r *http.Request) (release func(), ok bool) {
+	key, _ := r.Context().Value(clientKeyContextKey{}).([16]byte)
+	heldRequestsMu.Lock()
+	defer heldRequestsMu.Unlock()
+	if *maxHeldPerIP > 0 && heldRequests[key] >= *maxHeldPerIP {
+		return nil, false
+	}
+	heldRequests[key]++
+	return func() {
+		heldRequestsMu.Lock()
+		defer heldRequestsMu.Unlock()
+		if heldRequests[key]--; heldRequests[key] <= 0 {
+			delete(heldRequests, key)
+		}
+	}, true
+}
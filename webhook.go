@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// webhookWorkers is the number of notifications delivered concurrently
+	webhookWorkers = 4
+	// webhookQueueSize is how many notifications may wait for delivery before new ones are dropped
+	webhookQueueSize = 256
+)
+
+// webhookEvent is the JSON body posted to -webhookURL when a key is updated.
+// The value itself is not included, receivers can fetch it if they need it.
+type webhookEvent struct {
+	Key        string `json:"key"`
+	ETag       string `json:"etag"`
+	Size       int    `json:"size"`
+	LastUpdate int64  `json:"lastUpdate"`
+	ExpiresIn  int64  `json:"expiresIn"`
+}
+
+var (
+	// webhookQueue holds notifications waiting for a worker, nil if webhooks are disabled
+	webhookQueue  chan webhookEvent
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// validateWebhookURL checks that -webhookURL is an absolute http(s) URL
+func validateWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// startWebhooks starts the workers delivering notifications to -webhookURL
+func startWebhooks() {
+	webhookQueue = make(chan webhookEvent, webhookQueueSize)
+	for range webhookWorkers {
+		go func() {
+			for event := range webhookQueue {
+				deliverWebhook(event)
+			}
+		}()
+	}
+}
+
+// notifyWebhook queues a notification about the updated key if it matches -webhookPrefix.
+// Never blocks, notifications are dropped while the queue is full.
+func notifyWebhook(key string, entry *Entry) {
+	if webhookQueue == nil || !strings.HasPrefix(key, *webhookPrefix) {
+		return
+	}
+	event := webhookEvent{
+		Key:        key,
+		ETag:       entry.etag(),
+		Size:       len(entry.value()),
+		LastUpdate: entry.LastUpdate,
+		ExpiresIn:  int64(entry.expiration(key) / time.Second),
+	}
+	select {
+	case webhookQueue <- event:
+	default:
+		webhookFailedTotal.Add(1)
+	}
+}
+
+// deliverWebhook posts a single notification, failures are counted and logged but not retried
+func deliverWebhook(event webhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	resp, err := webhookClient.Post(*webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		webhookFailedTotal.Add(1)
+		log.Printf("Webhook for %s failed: %v", event.Key, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		webhookFailedTotal.Add(1)
+		log.Printf("Webhook for %s failed: %s", event.Key, resp.Status)
+	}
+}